@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/ttys3/reg/registry"
+)
+
+const cacheHelp = `Manage the on-disk manifest and blob cache.`
+
+func (cmd *cacheCommand) Name() string      { return "cache" }
+func (cmd *cacheCommand) Args() string      { return "prune [OPTIONS]" }
+func (cmd *cacheCommand) ShortHelp() string { return cacheHelp }
+func (cmd *cacheCommand) LongHelp() string {
+	return cacheHelp + "\n\nThe cache is used by all commands when --cache-dir is set.\nprune removes entries unused for longer than --max-age, then the least recently used ones until the cache fits in --max-size."
+}
+func (cmd *cacheCommand) Hidden() bool { return false }
+
+func (cmd *cacheCommand) Register(fs *flag.FlagSet) {
+	fs.StringVar(&cmd.maxSize, "max-size", "", "maximum size of the cache (ex. 2GB)")
+	fs.DurationVar(&cmd.maxAge, "max-age", 0, "remove entries unused for longer than this (ex. 720h)")
+}
+
+type cacheCommand struct {
+	maxSize string
+	maxAge  time.Duration
+}
+
+func (cmd *cacheCommand) Run(ctx context.Context, args []string) error {
+	if len(args) < 1 || args[0] != "prune" {
+		return fmt.Errorf("pass the cache subcommand: prune")
+	}
+
+	// Allow the options after the subcommand as well.
+	fs := flag.NewFlagSet("prune", flag.ContinueOnError)
+	cmd.Register(fs)
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	if cacheDir == "" {
+		return fmt.Errorf("pass the cache directory with --cache-dir")
+	}
+
+	var maxSize int64
+	if cmd.maxSize != "" {
+		size, err := humanize.ParseBytes(cmd.maxSize)
+		if err != nil {
+			return fmt.Errorf("parsing max size %q failed: %v", cmd.maxSize, err)
+		}
+		maxSize = int64(size)
+	}
+	if maxSize == 0 && cmd.maxAge == 0 {
+		return fmt.Errorf("pass --max-size, --max-age or both")
+	}
+
+	c, err := registry.NewCache(cacheDir)
+	if err != nil {
+		return err
+	}
+
+	removed, freed, err := c.Prune(maxSize, cmd.maxAge)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Removed %d entries, freed %s\n", removed, humanize.Bytes(uint64(freed)))
+	return nil
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"archive/tar"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/sirupsen/logrus"
+	"github.com/ttys3/reg/registry"
+)
+
+const catHelp = `Print a file from an image.`
+
+func (cmd *catCommand) Name() string      { return "cat" }
+func (cmd *catCommand) Args() string      { return "[OPTIONS] NAME[:TAG|@DIGEST] PATH" }
+func (cmd *catCommand) ShortHelp() string { return catHelp }
+func (cmd *catCommand) LongHelp() string {
+	return catHelp + "\n\nPATH is looked up in the merged view of the layers, like in a container of the image:\nthe layer that wrote it last wins, whiteouts remove it and symbolic links are followed."
+}
+func (cmd *catCommand) Hidden() bool { return false }
+
+func (cmd *catCommand) Register(fs *flag.FlagSet) {}
+
+type catCommand struct{}
+
+func (cmd *catCommand) Run(ctx context.Context, args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("pass the name of the image and the path of the file")
+	}
+
+	image, err := registry.ParseImage(args[0])
+	if err != nil {
+		return err
+	}
+
+	// Create the registry client.
+	r, err := createRegistryClient(ctx, image.Domain)
+	if err != nil {
+		return err
+	}
+
+	m, _, err := r.ResolveManifest(ctx, image.Path, image.Reference(), registry.Platform{})
+	if err != nil {
+		return err
+	}
+
+	l := &fileLookup{r: r, repo: image.Path, layers: imageLayers(m)}
+	f, err := l.find(ctx, args[1])
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	_, err = io.Copy(os.Stdout, f)
+	return err
+}
+
+// maxLinks is the number of links followed when looking up a path, like the
+// limit of the Linux kernel.
+const maxLinks = 40
+
+// fileLookup finds files in the merged view of the layers of an image.
+type fileLookup struct {
+	r      *registry.Registry
+	repo   string
+	layers []distribution.Descriptor
+}
+
+// layerMatch is what a single layer holds for a path.
+type layerMatch struct {
+	// entry is the entry for the path, and content its content if it is a
+	// regular file.
+	entry   *tar.Header
+	content *os.File
+	// parent is an entry that replaces a parent directory of the path with
+	// something else, like a symbolic link.
+	parent *tar.Header
+	// dir is the deepest parent directory of the path the layer holds as a
+	// directory, by an entry for it or for something inside it.
+	dir string
+	// hidden is set if a whiteout removes the path from the layers below.
+	hidden bool
+}
+
+// find returns a temporary file with the content of the file name, which the
+// caller has to remove.
+func (l *fileLookup) find(ctx context.Context, name string) (*os.File, error) {
+	p := entryName(name)
+	links := 0
+	// dir is the deepest parent of p the layers above hold as a directory.
+	// A link that replaces it or one of its parents in a lower layer is not
+	// followed, it hides what the layers below it hold.
+	dir := ""
+	follow := func(from, target string) error {
+		if links++; links > maxLinks {
+			return fmt.Errorf("%s: too many levels of symbolic links", name)
+		}
+		if !path.IsAbs(target) {
+			target = path.Join(path.Dir("/"+from), target)
+		}
+		p = entryName(target)
+		dir = ""
+		return nil
+	}
+
+	for i := len(l.layers) - 1; i >= 0; i-- {
+		m, err := l.scan(ctx, l.layers[i], p)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case m.entry != nil:
+			switch m.entry.Typeflag {
+			case tar.TypeReg:
+				logrus.Infof("found %s in layer %s", p, l.layers[i].Digest)
+				return m.content, nil
+			case tar.TypeSymlink:
+				// Links resolve in the merged view, so start over from the top.
+				if err := follow(p, m.entry.Linkname); err != nil {
+					return nil, err
+				}
+				i = len(l.layers)
+			case tar.TypeLink:
+				// Hard links point to an earlier entry of the same layer.
+				if links++; links > maxLinks {
+					return nil, fmt.Errorf("%s: too many levels of links", name)
+				}
+				p = entryName(m.entry.Linkname)
+				dir = ""
+				i++
+			case tar.TypeDir:
+				return nil, fmt.Errorf("%s: is a directory", name)
+			default:
+				return nil, fmt.Errorf("%s: not a regular file", name)
+			}
+		case m.parent != nil:
+			parent := entryName(m.parent.Name)
+			if m.parent.Typeflag != tar.TypeSymlink || (dir != "" && isUnder(dir, parent)) {
+				return nil, fmt.Errorf("%s: %w", name, fs.ErrNotExist)
+			}
+			rest := strings.TrimPrefix(p, parent)
+			if err := follow(parent, m.parent.Linkname); err != nil {
+				return nil, err
+			}
+			p = entryName(p + rest)
+			i = len(l.layers)
+		case m.hidden:
+			return nil, fmt.Errorf("%s: %w", name, fs.ErrNotExist)
+		case len(m.dir) > len(dir):
+			dir = m.dir
+		}
+	}
+	return nil, fmt.Errorf("%s: %w", name, fs.ErrNotExist)
+}
+
+// scan reads a layer looking for the path p.
+func (l *fileLookup) scan(ctx context.Context, layer distribution.Descriptor, p string) (layerMatch, error) {
+	var m layerMatch
+	err := walkLayer(ctx, l.r, l.repo, layer, func(hdr *tar.Header, content io.Reader) error {
+		name := entryName(hdr.Name)
+		dir, base := path.Split(name)
+		dir = strings.TrimSuffix(dir, "/")
+
+		if !strings.HasPrefix(base, whiteoutPrefix) {
+			// An entry makes its parents directories, and a directory
+			// replaces an earlier entry of the same name.
+			parent := dir
+			if hdr.Typeflag == tar.TypeDir {
+				parent = name
+				if m.parent != nil && entryName(m.parent.Name) == name {
+					m.parent = nil
+				}
+			}
+			for ; parent != "." && parent != ""; parent = path.Dir(parent) {
+				if parent != p && isUnder(p, parent) {
+					if len(parent) > len(m.dir) {
+						m.dir = parent
+					}
+					break
+				}
+			}
+		}
+
+		switch {
+		case name == p:
+			// A later entry of the same name replaces an earlier one.
+			m.entry = hdr
+			if m.content != nil {
+				m.content.Close()
+				os.Remove(m.content.Name())
+				m.content = nil
+			}
+			if hdr.Typeflag == tar.TypeReg {
+				f, err := os.CreateTemp("", "reg-cat-")
+				if err != nil {
+					return err
+				}
+				m.content = f
+				if _, err := io.Copy(f, content); err != nil {
+					return err
+				}
+			}
+		case isUnder(p, name) && hdr.Typeflag != tar.TypeDir:
+			m.parent = hdr
+		case base == opaqueWhiteout && isUnder(p, dir) && p != dir:
+			m.hidden = true
+		case strings.HasPrefix(base, whiteoutPrefix) && isUnder(p, path.Join(dir, strings.TrimPrefix(base, whiteoutPrefix))):
+			m.hidden = true
+		}
+		return nil
+	})
+	if err == nil && m.content != nil {
+		_, err = m.content.Seek(0, io.SeekStart)
+	}
+	if err != nil && m.content != nil {
+		m.content.Close()
+		os.Remove(m.content.Name())
+	}
+	return m, err
+}
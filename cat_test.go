@@ -0,0 +1,225 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/manifest/schema2"
+	"github.com/docker/docker/api/types"
+	digest "github.com/opencontainers/go-digest"
+	"github.com/ttys3/reg/registry"
+)
+
+func TestCat(t *testing.T) {
+	image := fmt.Sprintf("%s/busybox:latest", domain)
+
+	out, err := run("cat", image, "/etc/passwd")
+	if err != nil {
+		t.Fatalf("output: %s, error: %v", out, err)
+	}
+	if !strings.Contains(out, "root:x:0:0:") {
+		t.Fatalf("expected the passwd file, got: %s", out)
+	}
+
+	if out, err := run("cat", image, "/etc/does-not-exist"); err == nil {
+		t.Fatalf("expected an error for a missing file, got: %s", out)
+	}
+	if out, err := run("cat", image, "/etc"); err == nil || !strings.Contains(out, "is a directory") {
+		t.Fatalf("expected an error for a directory, got: %s, %v", out, err)
+	}
+}
+
+// layerEntry is an entry of a test layer. Names ending in a slash are
+// directories, entries with a linkname are symbolic links.
+type layerEntry struct {
+	name     string
+	linkname string
+	content  string
+	hardlink bool
+}
+
+// layerRegistry serves the layers, from the base layer up, as blobs of the
+// repository test.
+func layerRegistry(t *testing.T, layers ...[]layerEntry) (*registry.Registry, []distribution.Descriptor) {
+	t.Helper()
+
+	blobs := map[string][]byte{}
+	var descs []distribution.Descriptor
+	for _, entries := range layers {
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		for _, e := range entries {
+			hdr := &tar.Header{Name: e.name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(e.content))}
+			switch {
+			case strings.HasSuffix(e.name, "/"):
+				hdr.Typeflag, hdr.Mode, hdr.Size = tar.TypeDir, 0755, 0
+			case e.hardlink:
+				hdr.Typeflag, hdr.Linkname, hdr.Size = tar.TypeLink, e.linkname, 0
+			case e.linkname != "":
+				hdr.Typeflag, hdr.Linkname, hdr.Size = tar.TypeSymlink, e.linkname, 0
+			}
+			if err := tw.WriteHeader(hdr); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := io.WriteString(tw, e.content); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := tw.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		desc := distribution.Descriptor{
+			MediaType: schema2.MediaTypeUncompressedLayer,
+			Digest:    digest.FromBytes(buf.Bytes()),
+			Size:      int64(buf.Len()),
+		}
+		blobs["/v2/test/blobs/"+desc.Digest.String()] = buf.Bytes()
+		descs = append(descs, desc)
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Docker-Distribution-API-Version", "registry/2.0")
+		if r.URL.Path == "/v2/" {
+			return
+		}
+		blob, ok := blobs[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write(blob)
+	}))
+	t.Cleanup(ts.Close)
+
+	r, err := registry.New(context.Background(), types.AuthConfig{ServerAddress: ts.URL}, registry.Opt{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r, descs
+}
+
+func TestFileLookup(t *testing.T) {
+	testcases := []struct {
+		name   string
+		layers [][]layerEntry
+		path   string
+		want   string
+	}{
+		{
+			name: "upper file wins",
+			layers: [][]layerEntry{
+				{{name: "a/x", content: "lower"}},
+				{{name: "a/x", content: "upper"}},
+			},
+			path: "/a/x",
+			want: "upper",
+		},
+		{
+			name: "whiteout",
+			layers: [][]layerEntry{
+				{{name: "a/x", content: "lower"}},
+				{{name: "a/.wh.x"}},
+			},
+			path: "/a/x",
+		},
+		{
+			name: "opaque directory",
+			layers: [][]layerEntry{
+				{{name: "a/x", content: "lower"}},
+				{{name: "a/"}, {name: "a/.wh..wh..opq"}},
+			},
+			path: "/a/x",
+		},
+		{
+			name: "upper symlink parent",
+			layers: [][]layerEntry{
+				{{name: "a/x", content: "a"}, {name: "b/x", content: "b"}},
+				{{name: "a", linkname: "b"}},
+			},
+			path: "/a/x",
+			want: "b",
+		},
+		{
+			name: "hard link",
+			layers: [][]layerEntry{
+				{{name: "a/x", content: "x"}, {name: "a/y", linkname: "a/x", hardlink: true}},
+			},
+			path: "/a/y",
+			want: "x",
+		},
+		{
+			name: "lower symlink parent replaced by a directory",
+			layers: [][]layerEntry{
+				{{name: "a", linkname: "b"}, {name: "b/x", content: "b"}},
+				{{name: "a/"}},
+			},
+			path: "/a/x",
+		},
+		{
+			name: "lower symlink parent replaced by a directory with content",
+			layers: [][]layerEntry{
+				{{name: "a", linkname: "b"}, {name: "b/x", content: "b"}},
+				{{name: "a/y", content: "y"}},
+			},
+			path: "/a/x",
+		},
+		{
+			name: "directory behind a symlink an upper layer wrote into",
+			layers: [][]layerEntry{
+				{{name: "a/"}, {name: "a/x", content: "a"}},
+				{{name: "a", linkname: "b"}, {name: "b/x", content: "b"}},
+				{{name: "a/y", content: "y"}},
+			},
+			path: "/a/x",
+		},
+		{
+			name: "lower symlink below an upper directory",
+			layers: [][]layerEntry{
+				{{name: "a/c", linkname: "/b"}, {name: "b/x", content: "b"}},
+				{{name: "a/"}},
+			},
+			path: "/a/c/x",
+			want: "b",
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			r, layers := layerRegistry(t, tc.layers...)
+			l := &fileLookup{r: r, repo: "test", layers: layers}
+
+			f, err := l.find(context.Background(), tc.path)
+			if tc.want == "" {
+				if !errors.Is(err, fs.ErrNotExist) {
+					t.Fatalf("expected %s not to exist, got %v", tc.path, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.Remove(f.Name())
+			defer f.Close()
+
+			b, err := io.ReadAll(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != tc.want {
+				t.Fatalf("expected %q, got %q", tc.want, b)
+			}
+		})
+	}
+}
@@ -7,6 +7,8 @@ import (
 	"strings"
 
 	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+	"github.com/distribution/distribution/v3/manifest/schema2"
 	"github.com/quay/clair/v3/api/v3/clairpb"
 )
 
@@ -54,43 +56,34 @@ func (c *Clair) NewClairV3Layer(ctx context.Context, r *registry.Registry, image
 }
 
 func (c *Clair) getLayers(ctx context.Context, r *registry.Registry, repo, tag string, filterEmpty bool) (map[int]distribution.Descriptor, string, error) {
-	ok := true
-	// Get the manifest to pass to clair.
-	mf, err := r.ManifestV2(ctx, repo, tag)
+	// Get the manifest to pass to clair, picking the configured platform from a manifest list.
+	m, _, err := r.ResolveManifest(ctx, repo, tag, registry.Platform{})
 	if err != nil {
-		ok = false
-		c.Logf("couldn't retrieve manifest v2, falling back to v1")
+		return nil, "", fmt.Errorf("getting the manifest for %s:%s failed: %v", repo, tag, err)
 	}
 
-	filteredLayers := map[int]distribution.Descriptor{}
-
-	// Filter out the empty layers.
-	if ok {
-		for i := 0; i < len(mf.Layers); i++ {
-			if filterEmpty && IsEmptyLayer(mf.Layers[i].Digest) {
-				continue
-			} else {
-				filteredLayers[len(mf.Layers)-i-1] = mf.Layers[i]
-			}
-		}
-
-		return filteredLayers, mf.Config.Digest.String(), nil
+	var (
+		config distribution.Descriptor
+		layers []distribution.Descriptor
+	)
+	switch mf := m.(type) {
+	case *schema2.DeserializedManifest:
+		config, layers = mf.Config, mf.Layers
+	case *ocischema.DeserializedManifest:
+		config, layers = mf.Config, mf.Layers
+	default:
+		return nil, "", fmt.Errorf("unsupported manifest type %T for %s:%s", m, repo, tag)
 	}
 
-	m, err := r.ManifestOCI(ctx, repo, tag)
-	if err != nil {
-		return nil, "", fmt.Errorf("getting the v1 manifest for %s:%s failed: %v", repo, tag, err)
-	}
+	filteredLayers := map[int]distribution.Descriptor{}
 
-	for i := 0; i < len(m.Layers); i++ {
-		if filterEmpty && IsEmptyLayer(m.Layers[i].Digest) {
+	// Filter out the empty layers.
+	for i := 0; i < len(layers); i++ {
+		if filterEmpty && IsEmptyLayer(layers[i].Digest) {
 			continue
-		} else {
-			filteredLayers[i] = distribution.Descriptor{
-				Digest: m.Layers[i].Digest,
-			}
 		}
+		filteredLayers[len(layers)-i-1] = layers[i]
 	}
 
-	return filteredLayers, filteredLayers[0].Digest.String(), nil
+	return filteredLayers, config.Digest.String(), nil
 }
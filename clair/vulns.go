@@ -30,15 +30,23 @@ func (c *Clair) Vulnerabilities(ctx context.Context, r *registry.Registry, repo,
 		return report, nil
 	}
 
-	for i := len(filteredLayers) - 1; i >= 0; i-- {
-		// Form the clair layer.
-		l, err := c.NewClairLayer(ctx, r, repo, filteredLayers, i)
-		if err != nil {
-			return report, err
-		}
+	// Form the clair layers concurrently, each one needs its own token.
+	clairLayers := make([]*Layer, len(filteredLayers))
+	ex := registry.NewExecutor(r.Opt.Parallelism)
+	for i := range clairLayers {
+		i := i
+		ex.Go(ctx, filteredLayers[i].Digest.String(), func(ctx context.Context) (err error) {
+			clairLayers[i], err = c.NewClairLayer(ctx, r, repo, filteredLayers, i)
+			return err
+		})
+	}
+	if err := ex.Wait(); err != nil {
+		return report, err
+	}
 
-		// Post the layer.
-		if _, err := c.PostLayer(ctx, l); err != nil {
+	// Post the layers in order, every layer references its parent.
+	for i := len(clairLayers) - 1; i >= 0; i-- {
+		if _, err := c.PostLayer(ctx, clairLayers[i]); err != nil {
 			return report, err
 		}
 	}
@@ -98,16 +106,19 @@ func (c *Clair) VulnerabilitiesV3(ctx context.Context, r *registry.Registry, rep
 
 	report.Name = reportName
 
-	clairLayers := []*clairpb.PostAncestryRequest_PostLayer{}
-	for i := len(layers) - 1; i >= 0; i-- {
-		// Form the clair layer.
-		l, err := c.NewClairV3Layer(ctx, r, repo, layers[i])
-		if err != nil {
-			return report, err
-		}
-
-		// Append the layer.
-		clairLayers = append(clairLayers, l)
+	// Form the clair layers concurrently, from the base layer up.
+	clairLayers := make([]*clairpb.PostAncestryRequest_PostLayer, len(layers))
+	ex := registry.NewExecutor(r.Opt.Parallelism)
+	for i := range clairLayers {
+		i := i
+		layer := layers[len(layers)-1-i]
+		ex.Go(ctx, layer.Digest.String(), func(ctx context.Context) (err error) {
+			clairLayers[i], err = c.NewClairV3Layer(ctx, r, repo, layer)
+			return err
+		})
+	}
+	if err := ex.Wait(); err != nil {
+		return report, err
 	}
 
 	// Post the ancestry.
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/distribution/distribution/v3"
+	digest "github.com/opencontainers/go-digest"
+	"github.com/sirupsen/logrus"
+	"github.com/ttys3/reg/registry"
+)
+
+const cpHelp = `Copy an image between repositories and registries.`
+
+func (cmd *cpCommand) Name() string      { return "cp" }
+func (cmd *cpCommand) Args() string      { return "[OPTIONS] SRC_NAME[:TAG|@DIGEST] DST_NAME[:TAG]" }
+func (cmd *cpCommand) ShortHelp() string { return cpHelp }
+func (cmd *cpCommand) LongHelp() string  { return cpHelp }
+func (cmd *cpCommand) Hidden() bool      { return false }
+
+func (cmd *cpCommand) Register(fs *flag.FlagSet) {}
+
+type cpCommand struct{}
+
+func (cmd *cpCommand) Run(ctx context.Context, args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("pass the name of the source and destination repositories")
+	}
+
+	// Only copy the requested platforms of a manifest list, all of them by default.
+	selectsPlatforms = true
+	platforms, err := registry.ParsePlatforms(platform)
+	if err != nil {
+		return err
+	}
+
+	src, err := registry.ParseImage(args[0])
+	if err != nil {
+		return err
+	}
+	dst, err := registry.ParseImage(args[1])
+	if err != nil {
+		return err
+	}
+
+	// Create the registry clients.
+	srcReg, err := createRegistryClient(ctx, src.Domain)
+	if err != nil {
+		return err
+	}
+	dstReg := srcReg
+	if dst.Domain != src.Domain {
+		dstReg, err = createRegistryClient(ctx, dst.Domain)
+		if err != nil {
+			return err
+		}
+	}
+
+	c := &imageCopier{
+		src:       srcReg,
+		srcRepo:   src.Path,
+		dst:       dstReg,
+		dstRepo:   dst.Path,
+		platforms: platforms,
+		copied:    map[digest.Digest]bool{},
+	}
+
+	d, err := c.copyManifest(ctx, src.Reference(), dst.Reference())
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Copied %s to %s@%s\n", src.String(), dst.String(), d)
+
+	return nil
+}
+
+// imageCopier copies manifests and the blobs they reference from one
+// repository to another.
+type imageCopier struct {
+	src     *registry.Registry
+	srcRepo string
+	dst     *registry.Registry
+	dstRepo string
+
+	platforms []registry.Platform
+	copied    map[digest.Digest]bool
+}
+
+// copyManifest copies the manifest ref and everything it references, and
+// pushes it as dstRef. It returns the digest of the pushed manifest.
+func (c *imageCopier) copyManifest(ctx context.Context, ref, dstRef string) (digest.Digest, error) {
+	m, _, err := c.src.Manifest(ctx, c.srcRepo, ref)
+	if err != nil {
+		return "", fmt.Errorf("getting manifest for %s:%s failed: %v", c.srcRepo, ref, err)
+	}
+
+	if registry.IsManifestList(m) {
+		var children []distribution.Descriptor
+		if m, children, err = selectPlatforms(m, c.platforms); err != nil {
+			return "", err
+		}
+		for _, child := range children {
+			if _, err := c.copyManifest(ctx, child.Digest.String(), child.Digest.String()); err != nil {
+				return "", err
+			}
+		}
+	} else {
+		for _, blob := range m.References() {
+			if err := c.copyBlob(ctx, blob); err != nil {
+				return "", err
+			}
+		}
+	}
+
+	logrus.Infof("pushing manifest %s:%s", c.dstRepo, dstRef)
+	d, err := c.dst.PutManifest(ctx, c.dstRepo, dstRef, m)
+	if err != nil {
+		return "", fmt.Errorf("pushing manifest %s:%s failed: %v", c.dstRepo, dstRef, err)
+	}
+
+	return d, nil
+}
+
+// copyBlob copies a single blob unless it already exists in the destination.
+func (c *imageCopier) copyBlob(ctx context.Context, blob distribution.Descriptor) error {
+	if c.copied[blob.Digest] {
+		return nil
+	}
+
+	if len(blob.URLs) > 0 {
+		// Foreign layers are not stored in the registry.
+		logrus.Infof("skipping foreign blob %s", blob.Digest)
+		return nil
+	}
+
+	// A mirror may have the blob even if the repository does not.
+	exists, err := c.dst.HasLayer(registry.WithoutMirrors(ctx), c.dstRepo, blob.Digest)
+	if err != nil {
+		return err
+	}
+	if exists {
+		logrus.Infof("blob %s already exists in %s, skipping", blob.Digest, c.dstRepo)
+		c.copied[blob.Digest] = true
+		return nil
+	}
+
+	download := func() (io.ReadCloser, error) {
+		return c.src.FetchBlobReader(ctx, c.srcRepo, blob)
+	}
+
+	if c.src == c.dst {
+		// Both repositories live on the same registry, so try to mount the blob.
+		logrus.Infof("mounting blob %s from %s into %s", blob.Digest, c.srcRepo, c.dstRepo)
+		if _, err := c.dst.MountLayer(ctx, c.dstRepo, c.srcRepo, blob.Digest, download); err != nil {
+			return fmt.Errorf("mounting blob %s failed: %v", blob.Digest, err)
+		}
+		c.copied[blob.Digest] = true
+		return nil
+	}
+
+	// Download the blob once, an upload that is resumed reads it again.
+	logrus.Infof("copying blob %s (%d bytes)", blob.Digest, blob.Size)
+	f, err := os.CreateTemp("", "reg-cp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	if err := c.src.FetchBlob(ctx, c.srcRepo, blob, f); err != nil {
+		return fmt.Errorf("downloading blob %s failed: %v", blob.Digest, err)
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	content := func() (io.ReadCloser, error) {
+		return io.NopCloser(io.NewSectionReader(f, 0, fi.Size())), nil
+	}
+
+	if err := c.dst.UploadBlob(ctx, c.dstRepo, blob, content); err != nil {
+		return fmt.Errorf("uploading blob %s failed: %v", blob.Digest, err)
+	}
+	c.copied[blob.Digest] = true
+	return nil
+}
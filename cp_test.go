@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestCopy(t *testing.T) {
+	image := fmt.Sprintf("%s/busybox:latest", domain)
+
+	before, err := run("digest", image)
+	if err != nil {
+		t.Fatalf("output: %s, error: %v", before, err)
+	}
+
+	// Copying an image onto itself skips every blob and must keep the digest.
+	out, err := run("cp", image, image)
+	if err != nil {
+		t.Fatalf("output: %s, error: %v", out, err)
+	}
+	if !strings.Contains(out, "already exists") {
+		t.Fatalf("expected existing blobs to be skipped, got: %s", out)
+	}
+
+	after, err := run("digest", image)
+	if err != nil {
+		t.Fatalf("output: %s, error: %v", after, err)
+	}
+	if lastLine(before) != lastLine(after) {
+		t.Fatalf("expected digest %s to be preserved, got %s", lastLine(before), lastLine(after))
+	}
+}
+
+func lastLine(s string) string {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
@@ -36,6 +36,16 @@ func (cmd *digestCommand) Run(ctx context.Context, args []string) error {
 		return err
 	}
 
+	if platform != "" {
+		// Get the digest of the manifest for the requested platform.
+		_, desc, err := r.ResolveManifest(ctx, image.Path, image.Reference(), registry.Platform{})
+		if err != nil {
+			return err
+		}
+		fmt.Println(desc.Digest.String())
+		return nil
+	}
+
 	// Get the digest.
 	digest, err := r.Digest(ctx, image)
 	if err != nil {
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/genuinetools/pkg/cli"
+	"github.com/ttys3/reg/registry"
+)
+
+// explainCommand wraps a command to add a hint on what to do to the registry
+// errors it returns, and to report the endpoints that served its reads.
+type explainCommand struct {
+	cli.Command
+}
+
+func (cmd *explainCommand) Run(ctx context.Context, args []string) error {
+	// Report the mirror use of failed commands as well, they need it most.
+	defer reportServed()
+	return explainError(cmd.Command.Run(ctx, args))
+}
+
+// explainError adds a hint to registry errors the user can act on.
+func explainError(err error) error {
+	var regErr *registry.Error
+	if !errors.As(err, &regErr) {
+		return err
+	}
+
+	var hint string
+	switch {
+	case errors.Is(regErr, registry.ErrUnauthorized):
+		hint = "check your credentials, pass --username and --password or run docker login"
+	case errors.Is(regErr, registry.ErrForbidden):
+		hint = "your credentials do not grant access to this repository"
+	case errors.Is(regErr, registry.ErrTooManyRequests):
+		hint = "the registry is rate limiting you, log in or try again later (see reg ratelimit)"
+	case regErr.HasCode(registry.ErrorCodeNameUnknown):
+		hint = "the repository does not exist, check its name"
+	case regErr.HasCode(registry.ErrorCodeManifestUnknown):
+		hint = "the tag or digest does not exist, list the tags with reg tags"
+	case errors.Is(regErr, registry.ErrUnsupported):
+		hint = "the registry does not support this operation"
+	default:
+		return err
+	}
+
+	return fmt.Errorf("%w\nhint: %s", err, hint)
+}
@@ -0,0 +1,444 @@
+package main
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/sirupsen/logrus"
+	"github.com/ttys3/reg/registry"
+)
+
+const exportHelp = `Export the flattened root filesystem of an image.`
+
+func (cmd *exportCommand) Name() string      { return "export" }
+func (cmd *exportCommand) Args() string      { return "[OPTIONS] NAME[:TAG|@DIGEST]" }
+func (cmd *exportCommand) ShortHelp() string { return exportHelp }
+func (cmd *exportCommand) LongHelp() string {
+	return exportHelp + "\n\nThe layers are merged like a container runtime does: files removed by whiteouts and\nthe content of opaque directories are left out, and only the last version of a file is kept.\nThe filesystem is written as a tarball with -o or extracted into a directory with --dir.\nOwners are only set in a directory when running as root, device files are skipped."
+}
+func (cmd *exportCommand) Hidden() bool { return false }
+
+func (cmd *exportCommand) Register(fs *flag.FlagSet) {
+	fs.StringVar(&cmd.output, "output", "", "tarball to write the root filesystem to")
+	fs.StringVar(&cmd.output, "o", "", "tarball to write the root filesystem to")
+	fs.StringVar(&cmd.dir, "dir", "", "directory to extract the root filesystem into")
+}
+
+type exportCommand struct {
+	output string
+	dir    string
+}
+
+func (cmd *exportCommand) Run(ctx context.Context, args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("pass the name of the image")
+	}
+	if (cmd.output == "") == (cmd.dir == "") {
+		return fmt.Errorf("pass either a tarball with -o or a directory with --dir")
+	}
+
+	image, err := registry.ParseImage(args[0])
+	if err != nil {
+		return err
+	}
+
+	// Create the registry client.
+	r, err := createRegistryClient(ctx, image.Domain)
+	if err != nil {
+		return err
+	}
+
+	m, desc, err := r.ResolveManifest(ctx, image.Path, image.Reference(), registry.Platform{})
+	if err != nil {
+		return err
+	}
+
+	if cmd.dir != "" {
+		if err := os.MkdirAll(cmd.dir, 0755); err != nil {
+			return err
+		}
+		if err := flatten(ctx, r, image.Path, imageLayers(m), newDirRootfs(cmd.dir)); err != nil {
+			return err
+		}
+		fmt.Printf("Exported %s@%s to %s\n", image.String(), desc.Digest, cmd.dir)
+		return nil
+	}
+
+	// Write to a temporary file next to the output, so that an interrupted
+	// export does not leave a partial tarball behind.
+	f, err := os.CreateTemp(filepath.Dir(cmd.output), "."+filepath.Base(cmd.output)+".part-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	if err := flatten(ctx, r, image.Path, imageLayers(m), &tarRootfs{tw: tar.NewWriter(f)}); err != nil {
+		return err
+	}
+	if err := f.Chmod(0644); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(f.Name(), cmd.output); err != nil {
+		return err
+	}
+
+	fmt.Printf("Exported %s@%s to %s\n", image.String(), desc.Digest, cmd.output)
+	return nil
+}
+
+// rootfs receives the entries of a flattened root filesystem. Entry names
+// are clean paths relative to the root.
+type rootfs interface {
+	WriteEntry(hdr *tar.Header, content io.Reader) error
+	Close() error
+}
+
+// flatten merges the layers, from the base layer up, into a single root
+// filesystem written to fs.
+//
+// The layers are applied from the top down, so every layer is streamed once
+// and nothing is written that a layer above replaced or removed: an entry is
+// skipped if a layer above wrote the same path, removed it or one of its
+// parents with a whiteout, made a parent opaque, or replaced a parent
+// directory with something else. Whiteouts only apply to the layers below
+// the one they are in, and so does an entry that is not a directory, which
+// hides the content of a directory of the same name below even if the layers
+// above make it a directory again.
+func flatten(ctx context.Context, r *registry.Registry, repo string, layers []distribution.Descriptor, fs rootfs) error {
+	f := newFlattener()
+	for i := len(layers) - 1; i >= 0; i-- {
+		logrus.Infof("applying layer %s (%d bytes)", layers[i].Digest, layers[i].Size)
+
+		// What this layer writes and removes only hides the layers below.
+		upper := newFlattener()
+		// Hard links to a file replaced above, by the name of the file.
+		orphans := map[string][]*tar.Header{}
+
+		if err := walkLayer(ctx, r, repo, layers[i], func(hdr *tar.Header, content io.Reader) error {
+			name := entryName(hdr.Name)
+			if name == "" || hdr.Typeflag == tar.TypeXGlobalHeader {
+				return nil
+			}
+			dir, base := path.Split(name)
+			dir = strings.TrimSuffix(dir, "/")
+
+			switch {
+			case base == opaqueWhiteout:
+				upper.opaque[dir] = true
+				return nil
+			case strings.HasPrefix(base, whiteoutPrefix):
+				upper.removed[path.Join(dir, strings.TrimPrefix(base, whiteoutPrefix))] = true
+				return nil
+			case f.hidden(name, hdr.Typeflag):
+				if hdr.Typeflag != tar.TypeDir {
+					// A directory above does not merge with the directories
+					// this entry replaced below.
+					upper.opaque[name] = true
+				}
+				return nil
+			}
+
+			hdr.Name = name
+			if hdr.Typeflag == tar.TypeLink {
+				hdr.Linkname = entryName(hdr.Linkname)
+				if f.hidden(hdr.Linkname, tar.TypeReg) {
+					// The link keeps the content the file had in this layer.
+					orphans[hdr.Linkname] = append(orphans[hdr.Linkname], hdr)
+					upper.add(name, tar.TypeReg)
+					return nil
+				}
+			}
+			if err := fs.WriteEntry(hdr, content); err != nil {
+				return fmt.Errorf("writing %s failed: %v", name, err)
+			}
+			upper.add(name, hdr.Typeflag)
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		if len(orphans) > 0 {
+			// Read the layer again for the content of the replaced files.
+			if err := walkLayer(ctx, r, repo, layers[i], func(hdr *tar.Header, content io.Reader) error {
+				links := orphans[entryName(hdr.Name)]
+				if hdr.Typeflag != tar.TypeReg || len(links) == 0 {
+					return nil
+				}
+				tmp, err := os.CreateTemp("", "reg-export-")
+				if err != nil {
+					return err
+				}
+				defer os.Remove(tmp.Name())
+				defer tmp.Close()
+				if _, err := io.Copy(tmp, content); err != nil {
+					return err
+				}
+
+				for _, link := range links {
+					if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+						return err
+					}
+					file := *hdr
+					file.Name = link.Name
+					if err := fs.WriteEntry(&file, tmp); err != nil {
+						return fmt.Errorf("writing %s failed: %v", link.Name, err)
+					}
+				}
+				return nil
+			}); err != nil {
+				return err
+			}
+		}
+
+		f.merge(upper)
+	}
+
+	return fs.Close()
+}
+
+// flattener tracks what the layers applied so far, the upper layers, wrote
+// and removed.
+type flattener struct {
+	// entries holds the type of every path written.
+	entries map[string]byte
+	// parents holds the parent directories of the entries, which may not have
+	// entries of their own.
+	parents map[string]bool
+	// removed holds the paths removed by whiteouts.
+	removed map[string]bool
+	// opaque holds the directories whose content in lower layers is hidden.
+	opaque map[string]bool
+}
+
+func newFlattener() *flattener {
+	return &flattener{
+		entries: map[string]byte{},
+		parents: map[string]bool{},
+		removed: map[string]bool{},
+		opaque:  map[string]bool{},
+	}
+}
+
+// add records an entry and its parent directories.
+func (f *flattener) add(name string, typeflag byte) {
+	f.entries[name] = typeflag
+	for dir := path.Dir(name); dir != "." && !f.parents[dir]; dir = path.Dir(dir) {
+		f.parents[dir] = true
+	}
+}
+
+// merge adds the entries and whiteouts of the layer below to the ones of f.
+// Entries of f win.
+func (f *flattener) merge(lower *flattener) {
+	for name, typeflag := range lower.entries {
+		if _, ok := f.entries[name]; !ok {
+			f.entries[name] = typeflag
+		}
+	}
+	for name := range lower.parents {
+		f.parents[name] = true
+	}
+	for name := range lower.removed {
+		f.removed[name] = true
+	}
+	for name := range lower.opaque {
+		f.opaque[name] = true
+	}
+}
+
+// hidden reports whether an entry of a lower layer is replaced or removed by
+// the upper layers. A directory is kept if the upper layers only wrote into
+// it, so that its owner and mode are not lost.
+func (f *flattener) hidden(name string, typeflag byte) bool {
+	if _, ok := f.entries[name]; ok || f.removed[name] {
+		return true
+	}
+	if f.parents[name] && typeflag != tar.TypeDir {
+		return true
+	}
+	for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+		if f.removed[dir] || f.opaque[dir] {
+			return true
+		}
+		if typeflag, ok := f.entries[dir]; ok && typeflag != tar.TypeDir {
+			return true
+		}
+	}
+	// A whiteout of the root directory hides everything below.
+	return f.opaque[""]
+}
+
+// tarRootfs writes a root filesystem as a tarball. Entries of the upper
+// layers come first, so parent directories may follow their content.
+type tarRootfs struct {
+	tw *tar.Writer
+}
+
+func (t *tarRootfs) WriteEntry(hdr *tar.Header, content io.Reader) error {
+	if hdr.Typeflag == tar.TypeDir {
+		hdr.Name += "/"
+	}
+	if err := t.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if hdr.Typeflag == tar.TypeReg {
+		_, err := io.Copy(t.tw, content)
+		return err
+	}
+	return nil
+}
+
+func (t *tarRootfs) Close() error {
+	return t.tw.Close()
+}
+
+// errParentNotDir is returned when a parent of an entry is not a directory.
+var errParentNotDir = errors.New("parent is not a directory")
+
+// dirRootfs extracts a root filesystem into a directory. The permissions of
+// directories are applied on Close, so that read-only directories can be
+// filled first.
+type dirRootfs struct {
+	root  string
+	chown bool
+	dirs  []*tar.Header
+}
+
+func newDirRootfs(root string) *dirRootfs {
+	return &dirRootfs{root: root, chown: os.Geteuid() == 0}
+}
+
+// path returns the path of the entry name in the directory, after checking
+// that none of its parents is a symbolic link, which could point outside of
+// the directory.
+func (d *dirRootfs) path(name string) (string, error) {
+	p := d.root
+	parts := strings.Split(name, "/")
+	for _, part := range parts[:len(parts)-1] {
+		p = filepath.Join(p, part)
+		fi, err := os.Lstat(p)
+		if os.IsNotExist(err) {
+			if err := os.Mkdir(p, 0755); err != nil {
+				return "", err
+			}
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		if !fi.IsDir() {
+			return "", fmt.Errorf("%w: %s", errParentNotDir, p)
+		}
+	}
+	return filepath.Join(p, parts[len(parts)-1]), nil
+}
+
+func (d *dirRootfs) WriteEntry(hdr *tar.Header, content io.Reader) error {
+	p, err := d.path(hdr.Name)
+	if errors.Is(err, errParentNotDir) {
+		// A layer that replaces a directory with a link and then writes into
+		// it is broken, skip the entry rather than follow the link.
+		logrus.Warnf("skipping %s: %v", hdr.Name, err)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if hdr.Typeflag == tar.TypeDir {
+		if fi, err := os.Lstat(p); err != nil || !fi.IsDir() {
+			os.RemoveAll(p)
+			if err := os.Mkdir(p, 0755); err != nil {
+				return err
+			}
+		}
+		d.dirs = append(d.dirs, hdr)
+		return nil
+	}
+
+	// Replace an entry of the same name, never write through it.
+	if fi, err := os.Lstat(p); err == nil {
+		if fi.IsDir() {
+			err = os.RemoveAll(p)
+		} else {
+			err = os.Remove(p)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	switch hdr.Typeflag {
+	case tar.TypeReg:
+		f, err := os.OpenFile(p, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(f, content); err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+	case tar.TypeSymlink:
+		if err := os.Symlink(hdr.Linkname, p); err != nil {
+			return err
+		}
+	case tar.TypeLink:
+		target, err := d.path(hdr.Linkname)
+		if err != nil {
+			return err
+		}
+		return os.Link(target, p)
+	default:
+		logrus.Warnf("skipping %s, device files and named pipes are not extracted", hdr.Name)
+		return nil
+	}
+	return d.setMetadata(p, hdr)
+}
+
+// setMetadata applies the owner, mode and modification time of an entry.
+func (d *dirRootfs) setMetadata(p string, hdr *tar.Header) error {
+	if d.chown {
+		if err := os.Lchown(p, hdr.Uid, hdr.Gid); err != nil {
+			return err
+		}
+	}
+	if hdr.Typeflag == tar.TypeSymlink {
+		return nil
+	}
+	// Chmod after chown, which clears the setuid and setgid bits.
+	if err := os.Chmod(p, hdr.FileInfo().Mode()); err != nil {
+		return err
+	}
+	return os.Chtimes(p, hdr.ModTime, hdr.ModTime)
+}
+
+func (d *dirRootfs) Close() error {
+	// Apply the deepest directories first, so the modification times of
+	// their parents are not changed afterwards.
+	sort.Slice(d.dirs, func(i, j int) bool {
+		return strings.Count(d.dirs[i].Name, "/") > strings.Count(d.dirs[j].Name, "/")
+	})
+	for _, hdr := range d.dirs {
+		if err := d.setMetadata(filepath.Join(d.root, hdr.Name), hdr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExport(t *testing.T) {
+	image := fmt.Sprintf("%s/busybox:latest", domain)
+
+	// Export as a tarball.
+	output := filepath.Join(t.TempDir(), "rootfs.tar")
+	out, err := run("export", "-o", output, image)
+	if err != nil {
+		t.Fatalf("output: %s, error: %v", out, err)
+	}
+	if !strings.Contains(out, "Exported "+image) {
+		t.Fatalf("expected the image to be exported, got: %s", out)
+	}
+
+	f, err := os.Open(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	found := false
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(hdr.Name, ".wh.") {
+			t.Fatalf("expected no whiteout files in the root filesystem, got %s", hdr.Name)
+		}
+		if hdr.Name == "bin/busybox" {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatal("expected bin/busybox in the root filesystem")
+	}
+
+	// Export into a directory.
+	dir := t.TempDir()
+	out, err = run("export", "--dir", dir, image)
+	if err != nil {
+		t.Fatalf("output: %s, error: %v", out, err)
+	}
+	b, err := os.ReadFile(filepath.Join(dir, "etc", "passwd"))
+	if err != nil {
+		t.Fatalf("expected etc/passwd in the directory: %v", err)
+	}
+	if !strings.HasPrefix(string(b), "root:") {
+		t.Fatalf("expected the passwd file, got: %s", b)
+	}
+}
+
+func TestFlatten(t *testing.T) {
+	// The root filesystems map the names of entries to their content, the
+	// target of symbolic links prefixed with an arrow, and directories to
+	// nothing, with a slash after their name.
+	testcases := []struct {
+		name   string
+		layers [][]layerEntry
+		want   map[string]string
+	}{
+		{
+			name: "whiteout",
+			layers: [][]layerEntry{
+				{{name: "a/"}, {name: "a/x", content: "x"}, {name: "a/y", content: "y"}},
+				{{name: "a/.wh.x"}},
+			},
+			want: map[string]string{"a/": "", "a/y": "y"},
+		},
+		{
+			name: "opaque directory",
+			layers: [][]layerEntry{
+				{{name: "a/"}, {name: "a/x", content: "x"}},
+				{{name: "a/"}, {name: "a/.wh..wh..opq"}, {name: "a/z", content: "z"}},
+			},
+			want: map[string]string{"a/": "", "a/z": "z"},
+		},
+		{
+			name: "directory replaced by a symlink and written into",
+			layers: [][]layerEntry{
+				{{name: "a/"}, {name: "a/x", content: "x"}, {name: "b/"}},
+				{{name: "a", linkname: "b"}},
+				{{name: "a/y", content: "y"}},
+			},
+			want: map[string]string{"a/": "", "a/y": "y", "b/": ""},
+		},
+		{
+			name: "hard link to a replaced file",
+			layers: [][]layerEntry{
+				{{name: "a/"}, {name: "a/x", content: "old"}, {name: "a/l", linkname: "a/x", hardlink: true}},
+				{{name: "a/x", content: "new"}},
+			},
+			want: map[string]string{"a/": "", "a/x": "new", "a/l": "old"},
+		},
+		{
+			name: "symlink parent replaced by a directory",
+			layers: [][]layerEntry{
+				{{name: "a", linkname: "b"}, {name: "b/"}, {name: "b/x", content: "b"}},
+				{{name: "a/"}, {name: "a/y", content: "y"}},
+			},
+			want: map[string]string{"a/": "", "a/y": "y", "b/": "", "b/x": "b"},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			r, layers := layerRegistry(t, tc.layers...)
+
+			var buf bytes.Buffer
+			if err := flatten(context.Background(), r, "test", layers, &tarRootfs{tw: tar.NewWriter(&buf)}); err != nil {
+				t.Fatal(err)
+			}
+			if got := readTarRootfs(t, &buf); !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("expected the tarball to hold %v, got %v", tc.want, got)
+			}
+
+			dir := t.TempDir()
+			if err := flatten(context.Background(), r, "test", layers, newDirRootfs(dir)); err != nil {
+				t.Fatal(err)
+			}
+			if got := readDirRootfs(t, dir); !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("expected the directory to hold %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func readTarRootfs(t *testing.T, r io.Reader) map[string]string {
+	t.Helper()
+
+	entries := map[string]string{}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := entries[hdr.Name]; ok {
+			t.Fatalf("expected a single entry for %s", hdr.Name)
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			entries[hdr.Name] = ""
+		case tar.TypeSymlink:
+			entries[hdr.Name] = "-> " + hdr.Linkname
+		case tar.TypeLink:
+			content, ok := entries[hdr.Linkname]
+			if !ok {
+				t.Fatalf("expected %s to link to an earlier entry, got %s", hdr.Name, hdr.Linkname)
+			}
+			entries[hdr.Name] = content
+		default:
+			b, err := io.ReadAll(tr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			entries[hdr.Name] = string(b)
+		}
+	}
+	return entries
+}
+
+func readDirRootfs(t *testing.T, root string) map[string]string {
+	t.Helper()
+
+	entries := map[string]string{}
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || p == root {
+			return err
+		}
+		name, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		name = filepath.ToSlash(name)
+
+		switch {
+		case d.IsDir():
+			entries[name+"/"] = ""
+		case d.Type()&fs.ModeSymlink != 0:
+			target, err := os.Readlink(p)
+			if err != nil {
+				return err
+			}
+			entries[name] = "-> " + target
+		default:
+			b, err := os.ReadFile(p)
+			if err != nil {
+				return err
+			}
+			entries[name] = string(b)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entries
+}
@@ -10,6 +10,7 @@ require (
 	github.com/dustin/go-humanize v1.0.1
 	github.com/genuinetools/pkg v0.0.0-20181022210355-2fcf164d37cb
 	github.com/google/go-cmp v0.5.9
+	github.com/klauspost/compress v1.17.9
 	github.com/labstack/echo/v4 v4.11.3
 	github.com/mitchellh/go-wordwrap v1.0.1
 	github.com/moby/term v0.5.0
@@ -19,6 +20,7 @@ require (
 	github.com/quay/clair/v3 v3.0.0-pre1
 	github.com/sirupsen/logrus v1.9.3
 	google.golang.org/grpc v1.59.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -53,6 +55,5 @@ require (
 	google.golang.org/genproto/googleapis/api v0.0.0-20231106174013-bbf56f31fb17 // indirect
 	google.golang.org/genproto/googleapis/rpc v0.0.0-20231106174013-bbf56f31fb17 // indirect
 	google.golang.org/protobuf v1.31.0 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 	gotest.tools/v3 v3.5.1 // indirect
 )
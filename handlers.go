@@ -63,53 +63,61 @@ func (rc *registryController) repositories(ctx context.Context, staticDir string
 		UpdateInterval: rc.interval,
 	}
 
-	repoList, err := rc.reg.Catalog(ctx, "")
-	if err != nil {
-		return fmt.Errorf("getting catalog for %s failed: %v", rc.reg.Domain, err)
-	}
-
-	var wg sync.WaitGroup
-	for _, repo := range repoList {
-		repoURI := fmt.Sprintf("%s/%s", rc.reg.Domain, repo)
-		r := Repository{
-			Name: repo,
-			URI:  repoURI,
-		}
+	// Generate the tags pages of every catalog page while the next one is
+	// fetched.
+	ex := registry.NewExecutor(rc.reg.Opt.Parallelism)
+	pager := rc.reg.CatalogPager(0, "")
+	for pager.Next(ctx) {
+		for _, repo := range pager.Page() {
+			repoURI := fmt.Sprintf("%s/%s", rc.reg.Domain, repo)
+			r := Repository{
+				Name: repo,
+				URI:  repoURI,
+			}
 
-		result.Repositories = append(result.Repositories, r)
+			result.Repositories = append(result.Repositories, r)
 
-		if !rc.generateOnly {
-			// Continue early because we don't need to generate the tags pages.
-			continue
-		}
-
-		// Generate the tags pages in a go routine.
-		wg.Add(1)
-		go func(repo string) {
-			defer wg.Done()
-			logrus.Infof("generating static tags page for repo %s", repo)
-
-			// Parse and execute the tags templates.
-			// If we are generating the tags files, disable vulnerability links in the
-			// templates since they won't go anywhere without a server side component.
-			b, err := rc.generateTagsTemplate(ctx, repo, false)
-			if err != nil {
-				logrus.Warnf("generating tags template for repo %q failed: %v", repo, err)
-			}
-			// Create the directory for the static tags files.
-			tagsDir := filepath.Join(staticDir, "repo", repo, "tags")
-			if err := os.MkdirAll(tagsDir, 0755); err != nil {
-				logrus.Warn(err)
+			if !rc.generateOnly {
+				// Continue early because we don't need to generate the tags pages.
+				continue
 			}
 
-			// Write the tags file.
-			tagsFile := filepath.Join(tagsDir, "index.html")
-			if err := ioutil.WriteFile(tagsFile, b, 0755); err != nil {
-				logrus.Warnf("writing tags template for repo %s to %sfailed: %v", repo, tagsFile, err)
-			}
-		}(repo)
+			// Generate the tags pages concurrently.
+			repo := repo
+			ex.Go(ctx, repo, func(ctx context.Context) error {
+				logrus.Infof("generating static tags page for repo %s", repo)
+
+				// Parse and execute the tags templates.
+				// If we are generating the tags files, disable vulnerability links in the
+				// templates since they won't go anywhere without a server side component.
+				b, err := rc.generateTagsTemplate(ctx, repo, false)
+				if err != nil {
+					logrus.Warnf("generating tags template for repo %q failed: %v", repo, err)
+				}
+				// Create the directory for the static tags files.
+				tagsDir := filepath.Join(staticDir, "repo", repo, "tags")
+				if err := os.MkdirAll(tagsDir, 0755); err != nil {
+					return err
+				}
+
+				// Write the tags file.
+				tagsFile := filepath.Join(tagsDir, "index.html")
+				if err := ioutil.WriteFile(tagsFile, b, 0755); err != nil {
+					return fmt.Errorf("writing tags template to %s failed: %v", tagsFile, err)
+				}
+				return nil
+			})
+		}
+	}
+	if err := pager.Err(); err != nil {
+		ex.Wait()
+		return fmt.Errorf("getting catalog for %s failed: %v", rc.reg.Domain, err)
+	}
+	if err := ex.Wait(); err != nil {
+		for _, e := range err.(registry.Errors) {
+			logrus.Warnf("generating static tags page for repo %s failed: %v", e.Item, e.Err)
+		}
 	}
-	wg.Wait()
 
 	// Parse & execute the template.
 	logrus.Info("executing the template repositories")
@@ -188,6 +196,11 @@ func (rc *registryController) generateTagsTemplate(ctx context.Context, repo str
 	}
 
 	for _, tag := range tags {
+		// Leave some of the pull quota for others.
+		if err := rc.reg.WaitRateLimit(ctx); err != nil {
+			return nil, err
+		}
+
 		// get the image creat time, for v2 or oci image,
 		// maybe someday we can get it from `org.opencontainers.image.created` annotation
 		// ref https://github.com/opencontainers/image-spec/blob/main/annotations.md#pre-defined-annotation-keys
@@ -257,7 +270,7 @@ func (rc *registryController) imageLayer(c echo.Context) error {
 	}
 	result.Image = &image
 
-	manefest, descriptor, err := rc.reg.Manifest(c.Request().Context(), repo, tag)
+	manefest, descriptor, err := rc.reg.ResolveManifest(c.Request().Context(), repo, tag, registry.Platform{})
 	if err != nil {
 		logrus.WithFields(logrus.Fields{
 			"func":   "vulnerabilities",
@@ -271,13 +284,9 @@ func (rc *registryController) imageLayer(c echo.Context) error {
 
 	layers := make([]*registry.Layer, 0, len(manefest.References()))
 
-	for idx, ref := range manefest.References() {
-		// skip the config reference
-		if idx == 0 {
-			continue
-		}
+	for idx, ref := range imageLayers(manefest) {
 		layers = append(layers, &registry.Layer{
-			Index:       int64(idx),
+			Index:       int64(idx + 1),
 			Digest:      ref.Digest,
 			Size:        ref.Size,
 			Command:     "",
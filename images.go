@@ -0,0 +1,148 @@
+package main
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/manifest/manifestlist"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+	"github.com/ttys3/reg/registry"
+)
+
+// selectPlatforms returns the entries of the manifest list or OCI index m
+// for the requested platforms, all of them if platforms is empty. Index
+// entries without a platform are always kept. If only some entries are
+// selected the list is rewritten, which changes its digest.
+func selectPlatforms(m distribution.Manifest, platforms []registry.Platform) (distribution.Manifest, []distribution.Descriptor, error) {
+	switch ml := m.(type) {
+	case *manifestlist.DeserializedManifestList:
+		var (
+			kept     []manifestlist.ManifestDescriptor
+			children []distribution.Descriptor
+		)
+		for i, child := range ml.Manifests {
+			p := registry.Platform{OS: child.Platform.OS, Architecture: child.Platform.Architecture, Variant: child.Platform.Variant}
+			if !wantPlatform(platforms, p) {
+				continue
+			}
+			kept = append(kept, child)
+			children = append(children, ml.References()[i])
+		}
+		if len(kept) != len(ml.Manifests) {
+			// Only a subset of the platforms was selected, so the list has to be rewritten.
+			rewritten, err := manifestlist.FromDescriptors(kept)
+			if err != nil {
+				return nil, nil, err
+			}
+			return rewritten, children, nil
+		}
+		return m, children, nil
+	case *ocischema.DeserializedImageIndex:
+		var kept []distribution.Descriptor
+		for _, child := range ml.Manifests {
+			if child.Platform != nil && !wantPlatform(platforms, registry.DescriptorPlatform(child)) {
+				continue
+			}
+			kept = append(kept, child)
+		}
+		if len(kept) != len(ml.Manifests) {
+			// Only a subset of the platforms was selected, so the index has to be rewritten.
+			rewritten, err := ocischema.FromDescriptors(kept, ml.Annotations)
+			if err != nil {
+				return nil, nil, err
+			}
+			return rewritten, kept, nil
+		}
+		return m, kept, nil
+	}
+	return m, nil, nil
+}
+
+// wantPlatform reports whether a manifest for platform p was requested.
+func wantPlatform(platforms []registry.Platform, p registry.Platform) bool {
+	if len(platforms) == 0 {
+		return true
+	}
+	for _, want := range platforms {
+		if want.Match(p) {
+			return true
+		}
+	}
+	return false
+}
+
+const (
+	// whiteoutPrefix marks a file that removes the file of the same name,
+	// without the prefix, from the layers below.
+	whiteoutPrefix = ".wh."
+	// opaqueWhiteout marks a directory whose content in the layers below is
+	// hidden.
+	opaqueWhiteout = whiteoutPrefix + whiteoutPrefix + ".opq"
+)
+
+// entryName returns the name of a tar entry as a clean path relative to the
+// root of the image, "" for the root itself.
+func entryName(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+// isUnder reports whether p is dir or inside it.
+func isUnder(p, dir string) bool {
+	return dir == "" || p == dir || strings.HasPrefix(p, dir+"/")
+}
+
+// imageLayers returns the layers of the image manifest m, from the base
+// layer up. The first reference of an image manifest is its config.
+func imageLayers(m distribution.Manifest) []distribution.Descriptor {
+	refs := m.References()
+	if len(refs) == 0 {
+		return nil
+	}
+	return refs[1:]
+}
+
+// walkLayer streams a layer, gzip, zstd or uncompressed, and calls fn for
+// each of its tar entries with a reader for the content of the entry. The
+// layer is read to the end, so it is verified against its digest by the time
+// walkLayer returns, even if fn already saw every entry it needed.
+func walkLayer(ctx context.Context, r *registry.Registry, repo string, layer distribution.Descriptor, fn func(hdr *tar.Header, content io.Reader) error) error {
+	blob, err := r.DownloadBlob(ctx, repo, layer)
+	if err != nil {
+		return err
+	}
+	defer blob.Close()
+
+	rc, err := registry.Decompress(blob)
+	if err != nil {
+		return fmt.Errorf("reading layer %s failed: %v", layer.Digest, err)
+	}
+	defer rc.Close()
+
+	tr := tar.NewReader(rc)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("reading layer %s failed: %w", layer.Digest, err)
+		}
+		if err := fn(hdr, tr); err != nil {
+			return err
+		}
+	}
+
+	// Read past the end of the archive, so the digest is checked.
+	if _, err := io.Copy(io.Discard, rc); err != nil {
+		return fmt.Errorf("reading layer %s failed: %w", layer.Digest, err)
+	}
+	if _, err := io.Copy(io.Discard, blob); err != nil {
+		return fmt.Errorf("reading layer %s failed: %w", layer.Digest, err)
+	}
+	return nil
+}
@@ -4,9 +4,11 @@ import (
 	"context"
 	"flag"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"os"
+	"path/filepath"
 
+	"github.com/distribution/distribution/v3"
 	"github.com/ttys3/reg/registry"
 )
 
@@ -49,23 +51,36 @@ func (cmd *layerCommand) Run(ctx context.Context, args []string) error {
 		return err
 	}
 
-	// Download the layer.
-	layer, err := r.DownloadLayer(ctx, image.Path, digest)
-	if err != nil {
+	// Download the layer in parallel ranges where the registry supports them.
+	desc := distribution.Descriptor{Digest: digest, Size: -1}
+	if len(cmd.output) == 0 {
+		layer, err := r.FetchBlobReader(ctx, image.Path, desc)
+		if err != nil {
+			return err
+		}
+		defer layer.Close()
+
+		_, err = io.Copy(os.Stdout, layer)
 		return err
 	}
-	defer layer.Close()
 
-	b, err := ioutil.ReadAll(layer)
+	// Write to a temporary file next to the output, so that an interrupted
+	// download does not leave a partial layer behind.
+	f, err := os.CreateTemp(filepath.Dir(cmd.output), "."+filepath.Base(cmd.output)+".part-")
 	if err != nil {
 		return err
 	}
+	defer os.Remove(f.Name())
+	defer f.Close()
 
-	if len(cmd.output) > 0 {
-		return ioutil.WriteFile(cmd.output, b, 0644)
+	if err := r.FetchBlob(ctx, image.Path, desc, f); err != nil {
+		return err
 	}
-
-	fmt.Fprint(os.Stdout, string(b))
-
-	return nil
+	if err := f.Chmod(0644); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), cmd.output)
 }
@@ -0,0 +1,263 @@
+package layout
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/manifest/schema2"
+	digest "github.com/opencontainers/go-digest"
+)
+
+// archiveManifestFile is the file listing the images of a docker archive.
+const archiveManifestFile = "manifest.json"
+
+// ArchiveManifest is an entry of the manifest.json of a docker archive.
+type ArchiveManifest struct {
+	Config   string
+	RepoTags []string
+	Layers   []string
+}
+
+// Archive is a tarball in the format written by docker save and podman save,
+// known as docker-archive. Files are read from the tarball in place.
+type Archive struct {
+	f       *os.File
+	tmp     bool
+	entries map[string]archiveEntry
+
+	// Manifest lists the images in the archive.
+	Manifest []ArchiveManifest
+}
+
+// archiveEntry is the position of a file in the tarball.
+type archiveEntry struct {
+	offset int64
+	size   int64
+}
+
+// OpenArchive opens the docker archive at p. Gzip compressed archives are
+// decompressed into a temporary file first.
+func OpenArchive(p string) (*Archive, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	a := &Archive{f: f}
+
+	if compressed, err := isGzip(f); err != nil {
+		a.Close()
+		return nil, err
+	} else if compressed {
+		if err := a.decompress(); err != nil {
+			a.Close()
+			return nil, err
+		}
+	}
+
+	if err := a.index(); err != nil {
+		a.Close()
+		return nil, fmt.Errorf("reading archive %s failed: %v", p, err)
+	}
+
+	b, err := a.readFile(archiveManifestFile)
+	if err != nil {
+		a.Close()
+		return nil, fmt.Errorf("%s is not a docker archive: %v", p, err)
+	}
+	if err := json.Unmarshal(b, &a.Manifest); err != nil {
+		a.Close()
+		return nil, fmt.Errorf("parsing %s of %s failed: %v", archiveManifestFile, p, err)
+	}
+	return a, nil
+}
+
+// Close closes the archive.
+func (a *Archive) Close() error {
+	err := a.f.Close()
+	if a.tmp {
+		os.Remove(a.f.Name())
+	}
+	return err
+}
+
+// decompress replaces the archive with a decompressed temporary copy.
+func (a *Archive) decompress() error {
+	zr, err := gzip.NewReader(a.f)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	tmp, err := os.CreateTemp("", "reg-archive-")
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(tmp, zr); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	a.f.Close()
+	a.f, a.tmp = tmp, true
+	return nil
+}
+
+// index records where every regular file of the tarball starts.
+func (a *Archive) index() error {
+	if _, err := a.f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	cr := &countingReader{r: a.f}
+	tr := tar.NewReader(cr)
+	a.entries = map[string]archiveEntry{}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch hdr.Typeflag {
+		case tar.TypeReg:
+			// The content of the entry starts right after its header.
+			a.entries[path.Clean(hdr.Name)] = archiveEntry{offset: cr.n, size: hdr.Size}
+		case tar.TypeSymlink:
+			// docker save links layers shared between images.
+			target := path.Join(path.Dir(hdr.Name), hdr.Linkname)
+			if e, ok := a.entries[path.Clean(target)]; ok {
+				a.entries[path.Clean(hdr.Name)] = e
+			}
+		}
+	}
+}
+
+// Open returns the content of the file name in the archive.
+func (a *Archive) Open(name string) (*io.SectionReader, error) {
+	e, ok := a.entries[path.Clean(name)]
+	if !ok {
+		return nil, fmt.Errorf("file %s: %w", name, ErrNotFound)
+	}
+	return io.NewSectionReader(a.f, e.offset, e.size), nil
+}
+
+func (a *Archive) readFile(name string) ([]byte, error) {
+	r, err := a.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+// Image returns a schema2 manifest for the image of the archive tagged ref,
+// or for its only image if ref is empty. The layers are described as they
+// are stored in the archive, usually uncompressed. The second return value
+// maps the digest of every blob the manifest references to its file in the
+// archive.
+func (a *Archive) Image(ref string) (*schema2.DeserializedManifest, map[digest.Digest]string, error) {
+	am, err := a.findImage(ref)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	files := map[digest.Digest]string{}
+	config, err := a.describe(am.Config, schema2.MediaTypeImageConfig)
+	if err != nil {
+		return nil, nil, err
+	}
+	files[config.Digest] = am.Config
+
+	var layers []distribution.Descriptor
+	for _, name := range am.Layers {
+		layer, err := a.describe(name, "")
+		if err != nil {
+			return nil, nil, err
+		}
+		layers = append(layers, layer)
+		files[layer.Digest] = name
+	}
+
+	m, err := schema2.FromStruct(schema2.Manifest{
+		Versioned: schema2.SchemaVersion,
+		Config:    config,
+		Layers:    layers,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return m, files, nil
+}
+
+func (a *Archive) findImage(ref string) (ArchiveManifest, error) {
+	if ref == "" {
+		if len(a.Manifest) == 1 {
+			return a.Manifest[0], nil
+		}
+		return ArchiveManifest{}, fmt.Errorf("the archive holds %d images, pick one by tag", len(a.Manifest))
+	}
+
+	for _, m := range a.Manifest {
+		for _, tag := range m.RepoTags {
+			if tag == ref || strings.TrimPrefix(tag, "docker.io/") == ref || strings.TrimPrefix(tag, "localhost/") == ref {
+				return m, nil
+			}
+		}
+	}
+	return ArchiveManifest{}, fmt.Errorf("image %s: %w", ref, ErrNotFound)
+}
+
+// describe hashes a file of the archive. An empty media type is detected
+// from the content as a compressed or uncompressed layer.
+func (a *Archive) describe(name, mediaType string) (distribution.Descriptor, error) {
+	r, err := a.Open(name)
+	if err != nil {
+		return distribution.Descriptor{}, err
+	}
+
+	if mediaType == "" {
+		mediaType = schema2.MediaTypeUncompressedLayer
+		if compressed, err := isGzip(r); err != nil {
+			return distribution.Descriptor{}, err
+		} else if compressed {
+			mediaType = schema2.MediaTypeLayer
+		}
+	}
+
+	d, err := digest.FromReader(io.NewSectionReader(r, 0, r.Size()))
+	if err != nil {
+		return distribution.Descriptor{}, fmt.Errorf("hashing %s failed: %v", name, err)
+	}
+	return distribution.Descriptor{MediaType: mediaType, Digest: d, Size: r.Size()}, nil
+}
+
+// isGzip reports whether r starts with the gzip magic number.
+func isGzip(r io.ReaderAt) (bool, error) {
+	magic := make([]byte, 2)
+	n, err := r.ReadAt(magic, 0)
+	if err != nil && err != io.EOF {
+		return false, err
+	}
+	return n == 2 && bytes.Equal(magic, []byte{0x1f, 0x8b}), nil
+}
+
+// countingReader counts the bytes read from r.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
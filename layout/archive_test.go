@@ -0,0 +1,149 @@
+package layout
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/distribution/distribution/v3/manifest/schema2"
+	digest "github.com/opencontainers/go-digest"
+)
+
+type archiveFile struct {
+	name string
+	body []byte
+	link string
+}
+
+func writeArchive(t *testing.T, compress bool, files []archiveFile) string {
+	t.Helper()
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, f := range files {
+		hdr := &tar.Header{Name: f.name, Mode: 0644, Size: int64(len(f.body)), Typeflag: tar.TypeReg}
+		if f.link != "" {
+			hdr = &tar.Header{Name: f.name, Mode: 0777, Linkname: f.link, Typeflag: tar.TypeSymlink}
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(f.body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	b := buf.Bytes()
+	if compress {
+		b = gzipBytes(b)
+	}
+
+	p := filepath.Join(t.TempDir(), "images.tar")
+	if err := os.WriteFile(p, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func gzipBytes(b []byte) []byte {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write(b)
+	zw.Close()
+	return buf.Bytes()
+}
+
+func TestArchiveImage(t *testing.T) {
+	config := []byte(`{"architecture":"amd64","os":"linux"}`)
+	plain := []byte("uncompressed layer")
+	compressed := gzipBytes([]byte("compressed layer"))
+
+	manifest, err := json.Marshal([]ArchiveManifest{
+		{Config: "config.json", RepoTags: []string{"alpine:3.18"}, Layers: []string{"a/layer.tar", "b/layer.tar"}},
+		{Config: "config.json", RepoTags: []string{"localhost/htop:latest"}, Layers: []string{"c/layer.tar"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := []archiveFile{
+		{name: "config.json", body: config},
+		{name: "a/layer.tar", body: plain},
+		{name: "b/layer.tar", body: compressed},
+		// docker save links layers shared between images.
+		{name: "c/layer.tar", link: "../a/layer.tar"},
+		{name: "manifest.json", body: manifest},
+	}
+
+	for _, compress := range []bool{false, true} {
+		a, err := OpenArchive(writeArchive(t, compress, files))
+		if err != nil {
+			t.Fatalf("OpenArchive (compressed: %t): %v", compress, err)
+		}
+		defer a.Close()
+
+		if _, _, err := a.Image(""); err == nil {
+			t.Fatal("expected an error picking an image from an archive with two images")
+		}
+		if _, _, err := a.Image("busybox:latest"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected ErrNotFound for a missing image, got %v", err)
+		}
+
+		m, blobs, err := a.Image("alpine:3.18")
+		if err != nil {
+			t.Fatalf("Image: %v", err)
+		}
+		if m.Config.MediaType != schema2.MediaTypeImageConfig || m.Config.Digest != digest.FromBytes(config) || m.Config.Size != int64(len(config)) {
+			t.Fatalf("unexpected config descriptor: %+v", m.Config)
+		}
+		if len(m.Layers) != 2 {
+			t.Fatalf("expected 2 layers, got %d", len(m.Layers))
+		}
+		if m.Layers[0].MediaType != schema2.MediaTypeUncompressedLayer || m.Layers[0].Digest != digest.FromBytes(plain) {
+			t.Fatalf("unexpected uncompressed layer descriptor: %+v", m.Layers[0])
+		}
+		if m.Layers[1].MediaType != schema2.MediaTypeLayer || m.Layers[1].Digest != digest.FromBytes(compressed) {
+			t.Fatalf("unexpected compressed layer descriptor: %+v", m.Layers[1])
+		}
+
+		// Every blob can be read back by its file name.
+		for _, layer := range m.Layers {
+			r, err := a.Open(blobs[layer.Digest])
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if digest.FromBytes(b) != layer.Digest {
+				t.Fatalf("content of %s does not match its digest", blobs[layer.Digest])
+			}
+		}
+
+		// Tags without the localhost/ prefix podman adds match too, and
+		// symlinked layers resolve to their target.
+		m, _, err = a.Image("htop:latest")
+		if err != nil {
+			t.Fatalf("Image: %v", err)
+		}
+		if len(m.Layers) != 1 || m.Layers[0].Digest != digest.FromBytes(plain) {
+			t.Fatalf("expected the linked layer, got %+v", m.Layers)
+		}
+	}
+}
+
+func TestOpenArchiveNotAnArchive(t *testing.T) {
+	p := writeArchive(t, false, []archiveFile{{name: "index.json", body: []byte("{}")}})
+	if _, err := OpenArchive(p); err == nil {
+		t.Fatal("expected an error for a tarball without manifest.json")
+	}
+}
@@ -0,0 +1,169 @@
+package layout
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	digest "github.com/opencontainers/go-digest"
+)
+
+// archiveRepositoriesFile is the legacy file mapping repositories and tags to
+// the top layer of their image.
+const archiveRepositoriesFile = "repositories"
+
+// ArchiveWriter writes a docker archive, the tarball format docker load and
+// podman load read. Blobs are written as they are added and stored once
+// under blobs/<algorithm>/<hex>, so layers shared between images are only
+// written once. manifest.json and repositories are written on Close.
+type ArchiveWriter struct {
+	tw      *tar.Writer
+	written map[digest.Digest]bool
+
+	manifest     []ArchiveManifest
+	repositories map[string]map[string]string
+}
+
+// NewArchiveWriter returns an ArchiveWriter writing to w.
+func NewArchiveWriter(w io.Writer) *ArchiveWriter {
+	return &ArchiveWriter{
+		tw:           tar.NewWriter(w),
+		written:      map[digest.Digest]bool{},
+		repositories: map[string]map[string]string{},
+	}
+}
+
+func blobName(d digest.Digest) string {
+	return path.Join("blobs", d.Algorithm().String(), d.Encoded())
+}
+
+// HasBlob reports whether the blob with digest d was written already.
+func (w *ArchiveWriter) HasBlob(d digest.Digest) bool {
+	return w.written[d]
+}
+
+// WriteBlob writes the blob described by desc, read from r, unless it was
+// written already. The size of desc has to be known. The content is verified
+// against the digest, a mismatch leaves the archive unusable.
+func (w *ArchiveWriter) WriteBlob(desc distribution.Descriptor, r io.Reader) error {
+	if err := desc.Digest.Validate(); err != nil {
+		return err
+	}
+	if w.written[desc.Digest] {
+		return nil
+	}
+	if desc.Size < 0 {
+		return fmt.Errorf("writing blob %s failed: unknown size", desc.Digest)
+	}
+
+	if err := w.tw.WriteHeader(&tar.Header{
+		Name:     blobName(desc.Digest),
+		Mode:     0644,
+		Size:     desc.Size,
+		ModTime:  time.Unix(0, 0),
+		Typeflag: tar.TypeReg,
+	}); err != nil {
+		return err
+	}
+
+	verifier := desc.Digest.Verifier()
+	n, err := io.Copy(io.MultiWriter(w.tw, verifier), r)
+	if err != nil {
+		return fmt.Errorf("writing blob %s failed: %v", desc.Digest, err)
+	}
+	if n != desc.Size || !verifier.Verified() {
+		return fmt.Errorf("writing blob %s failed: content does not match its digest", desc.Digest)
+	}
+	w.written[desc.Digest] = true
+	return nil
+}
+
+// AddImage lists an image in manifest.json, tagged with tags in the familiar
+// form docker uses, like alpine:3.18. Its config and layers have to be
+// written with WriteBlob first. Adding the same image again adds its tags to
+// the existing entry.
+func (w *ArchiveWriter) AddImage(config distribution.Descriptor, layers []distribution.Descriptor, tags []string) error {
+	entry := ArchiveManifest{Config: blobName(config.Digest)}
+	for _, d := range append([]distribution.Descriptor{config}, layers...) {
+		if !w.written[d.Digest] {
+			return fmt.Errorf("blob %s: %w", d.Digest, ErrNotFound)
+		}
+	}
+	for _, layer := range layers {
+		entry.Layers = append(entry.Layers, blobName(layer.Digest))
+	}
+
+	// A tag can only point to one image.
+	for i := range w.manifest {
+		w.manifest[i].RepoTags = slices.DeleteFunc(w.manifest[i].RepoTags, func(tag string) bool {
+			return slices.Contains(tags, tag)
+		})
+	}
+
+	i := slices.IndexFunc(w.manifest, func(m ArchiveManifest) bool {
+		return m.Config == entry.Config && slices.Equal(m.Layers, entry.Layers)
+	})
+	if i < 0 {
+		w.manifest = append(w.manifest, entry)
+		i = len(w.manifest) - 1
+	}
+	w.manifest[i].RepoTags = append(w.manifest[i].RepoTags, tags...)
+
+	if len(layers) > 0 {
+		top := layers[len(layers)-1].Digest.Encoded()
+		for _, tag := range tags {
+			repo, t := tag, "latest"
+			if i := strings.LastIndex(tag, ":"); i > strings.LastIndex(tag, "/") {
+				repo, t = tag[:i], tag[i+1:]
+			}
+			if w.repositories[repo] == nil {
+				w.repositories[repo] = map[string]string{}
+			}
+			w.repositories[repo][t] = top
+		}
+	}
+	return nil
+}
+
+// Close writes manifest.json and repositories and finishes the archive. It
+// does not close the underlying writer.
+func (w *ArchiveWriter) Close() error {
+	manifest, err := json.Marshal(w.manifest)
+	if err != nil {
+		return err
+	}
+	if err := w.writeFile(archiveManifestFile, manifest); err != nil {
+		return err
+	}
+
+	if len(w.repositories) > 0 {
+		repositories, err := json.Marshal(w.repositories)
+		if err != nil {
+			return err
+		}
+		if err := w.writeFile(archiveRepositoriesFile, repositories); err != nil {
+			return err
+		}
+	}
+	return w.tw.Close()
+}
+
+func (w *ArchiveWriter) writeFile(name string, b []byte) error {
+	if err := w.tw.WriteHeader(&tar.Header{
+		Name:     name,
+		Mode:     0644,
+		Size:     int64(len(b)),
+		ModTime:  time.Unix(0, 0),
+		Typeflag: tar.TypeReg,
+	}); err != nil {
+		return err
+	}
+	_, err := w.tw.Write(b)
+	return err
+}
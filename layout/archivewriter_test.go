@@ -0,0 +1,126 @@
+package layout
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/distribution/distribution/v3"
+	digest "github.com/opencontainers/go-digest"
+)
+
+func blob(content string) (distribution.Descriptor, io.Reader) {
+	return distribution.Descriptor{Digest: digest.FromString(content), Size: int64(len(content))}, strings.NewReader(content)
+}
+
+func TestArchiveWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewArchiveWriter(&buf)
+
+	config1, r := blob(`{"os":"linux","architecture":"amd64"}`)
+	if err := w.WriteBlob(config1, r); err != nil {
+		t.Fatalf("WriteBlob: %v", err)
+	}
+	config2, r := blob(`{"os":"linux","architecture":"arm64"}`)
+	if err := w.WriteBlob(config2, r); err != nil {
+		t.Fatalf("WriteBlob: %v", err)
+	}
+	shared, r := blob("shared layer")
+	if err := w.WriteBlob(shared, r); err != nil {
+		t.Fatalf("WriteBlob: %v", err)
+	}
+	// Writing a blob twice is a no-op.
+	if err := w.WriteBlob(shared, strings.NewReader("shared layer")); err != nil {
+		t.Fatalf("WriteBlob: %v", err)
+	}
+	top, r := blob("top layer")
+	if err := w.WriteBlob(top, r); err != nil {
+		t.Fatalf("WriteBlob: %v", err)
+	}
+
+	// Content that does not match its digest is refused.
+	bad, _ := blob("other")
+	if err := NewArchiveWriter(io.Discard).WriteBlob(bad, strings.NewReader("tamper")); err == nil {
+		t.Fatal("expected an error for content that does not match its digest")
+	}
+
+	// Images can only reference blobs that were written.
+	missing, _ := blob("missing")
+	if err := w.AddImage(config1, []distribution.Descriptor{missing}, nil); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound for a missing layer, got %v", err)
+	}
+
+	if err := w.AddImage(config1, []distribution.Descriptor{shared, top}, []string{"alpine:3.18"}); err != nil {
+		t.Fatalf("AddImage: %v", err)
+	}
+	if err := w.AddImage(config2, []distribution.Descriptor{shared}, []string{"r.j3ss.co/htop:latest"}); err != nil {
+		t.Fatalf("AddImage: %v", err)
+	}
+	// The same image under another tag is merged into its entry.
+	if err := w.AddImage(config1, []distribution.Descriptor{shared, top}, []string{"alpine:latest"}); err != nil {
+		t.Fatalf("AddImage: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	// The shared layer is stored once.
+	tr := tar.NewReader(bytes.NewReader(buf.Bytes()))
+	names := map[string]int{}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names[hdr.Name]++
+	}
+	if names["blobs/sha256/"+shared.Digest.Encoded()] != 1 {
+		t.Fatalf("expected the shared layer once, got entries %v", names)
+	}
+
+	p := filepath.Join(t.TempDir(), "images.tar")
+	if err := os.WriteFile(p, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	a, err := OpenArchive(p)
+	if err != nil {
+		t.Fatalf("OpenArchive: %v", err)
+	}
+	defer a.Close()
+
+	if len(a.Manifest) != 2 {
+		t.Fatalf("expected 2 images, got %+v", a.Manifest)
+	}
+	if got := strings.Join(a.Manifest[0].RepoTags, ","); got != "alpine:3.18,alpine:latest" {
+		t.Fatalf("expected both alpine tags on the first image, got %s", got)
+	}
+
+	m, _, err := a.Image("r.j3ss.co/htop:latest")
+	if err != nil {
+		t.Fatalf("Image: %v", err)
+	}
+	if m.Config.Digest != config2.Digest || len(m.Layers) != 1 || m.Layers[0].Digest != shared.Digest {
+		t.Fatalf("unexpected image read back: %+v", m.Manifest)
+	}
+
+	r2, err := a.Open("repositories")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var repositories map[string]map[string]string
+	if err := json.NewDecoder(r2).Decode(&repositories); err != nil {
+		t.Fatal(err)
+	}
+	if repositories["alpine"]["3.18"] != top.Digest.Encoded() || repositories["r.j3ss.co/htop"]["latest"] != shared.Digest.Encoded() {
+		t.Fatalf("unexpected repositories: %v", repositories)
+	}
+}
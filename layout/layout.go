@@ -0,0 +1,303 @@
+package layout
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/distribution/distribution/v3"
+	digest "github.com/opencontainers/go-digest"
+	ociv1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+const indexFile = "index.json"
+
+// ErrNotFound is returned when a blob or reference is not in the layout.
+var ErrNotFound = errors.New("not found in layout")
+
+// Index is the index.json of an image layout, it lists the manifests the
+// layout holds.
+type Index struct {
+	SchemaVersion int                       `json:"schemaVersion"`
+	MediaType     string                    `json:"mediaType,omitempty"`
+	Manifests     []distribution.Descriptor `json:"manifests"`
+	Annotations   map[string]string         `json:"annotations,omitempty"`
+}
+
+// Layout is a directory in the OCI image layout format.
+// https://github.com/opencontainers/image-spec/blob/v1.0.2/image-layout.md
+//
+// Blobs are stored by digest under blobs/<algorithm>/<hex>, and the manifests
+// the layout holds are listed in index.json, named by the
+// org.opencontainers.image.ref.name annotation.
+type Layout struct {
+	dir string
+
+	mu    sync.Mutex
+	index Index
+}
+
+// Create opens the image layout in dir, creating the directory and layout
+// files if they do not exist yet.
+func Create(dir string) (*Layout, error) {
+	if err := os.MkdirAll(filepath.Join(dir, "blobs"), 0755); err != nil {
+		return nil, fmt.Errorf("creating image layout %s failed: %v", dir, err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, ociv1.ImageLayoutFile)); errors.Is(err, fs.ErrNotExist) {
+		b, err := json.Marshal(ociv1.ImageLayout{Version: ociv1.ImageLayoutVersion})
+		if err != nil {
+			return nil, err
+		}
+		if err := writeFile(filepath.Join(dir, ociv1.ImageLayoutFile), b); err != nil {
+			return nil, err
+		}
+	}
+
+	l := &Layout{
+		dir: dir,
+		index: Index{
+			SchemaVersion: 2,
+			MediaType:     ociv1.MediaTypeImageIndex,
+		},
+	}
+	if err := l.readIndex(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Open opens an existing image layout in dir.
+func Open(dir string) (*Layout, error) {
+	b, err := os.ReadFile(filepath.Join(dir, ociv1.ImageLayoutFile))
+	if err != nil {
+		return nil, fmt.Errorf("%s is not an image layout: %v", dir, err)
+	}
+	var version ociv1.ImageLayout
+	if err := json.Unmarshal(b, &version); err != nil {
+		return nil, fmt.Errorf("parsing %s failed: %v", ociv1.ImageLayoutFile, err)
+	}
+	if version.Version != ociv1.ImageLayoutVersion {
+		return nil, fmt.Errorf("unsupported image layout version %q", version.Version)
+	}
+
+	l := &Layout{dir: dir}
+	if err := l.readIndex(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Dir returns the directory of the layout.
+func (l *Layout) Dir() string {
+	return l.dir
+}
+
+// Index returns the index of the layout.
+func (l *Layout) Index() Index {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	index := l.index
+	index.Manifests = append([]distribution.Descriptor(nil), l.index.Manifests...)
+	return index
+}
+
+func (l *Layout) readIndex() error {
+	b, err := os.ReadFile(filepath.Join(l.dir, indexFile))
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(b, &l.index); err != nil {
+		return fmt.Errorf("parsing %s failed: %v", indexFile, err)
+	}
+	return nil
+}
+
+func (l *Layout) blobPath(d digest.Digest) string {
+	return filepath.Join(l.dir, "blobs", d.Algorithm().String(), d.Encoded())
+}
+
+// HasBlob reports whether the layout holds the blob with digest d.
+func (l *Layout) HasBlob(d digest.Digest) bool {
+	if d.Validate() != nil {
+		return false
+	}
+	_, err := os.Stat(l.blobPath(d))
+	return err == nil
+}
+
+// Blob opens the blob with digest d. The content is not verified, see
+// ReadBlob.
+func (l *Layout) Blob(d digest.Digest) (*os.File, error) {
+	if err := d.Validate(); err != nil {
+		return nil, err
+	}
+	f, err := os.Open(l.blobPath(d))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("blob %s: %w", d, ErrNotFound)
+	}
+	return f, err
+}
+
+// ReadBlob returns the content of the blob with digest d, after verifying it
+// against the digest. It is meant for small blobs like manifests and configs.
+func (l *Layout) ReadBlob(d digest.Digest) ([]byte, error) {
+	f, err := l.Blob(d)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	b, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	if d.Algorithm().FromBytes(b) != d {
+		return nil, fmt.Errorf("blob %s in %s does not match its digest", d, l.dir)
+	}
+	return b, nil
+}
+
+// WriteBlob stores the content read from r as the blob with digest d. The
+// content is verified against d, and only committed to the layout if it
+// matches.
+func (l *Layout) WriteBlob(d digest.Digest, r io.Reader) error {
+	if err := d.Validate(); err != nil {
+		return err
+	}
+
+	p := l.blobPath(d)
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(p), ".tmp-"+d.Encoded())
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	verifier := d.Verifier()
+	if _, err := io.Copy(io.MultiWriter(tmp, verifier), r); err != nil {
+		return fmt.Errorf("writing blob %s failed: %v", d, err)
+	}
+	if !verifier.Verified() {
+		return fmt.Errorf("writing blob %s failed: content does not match its digest", d)
+	}
+	// Temporary files are only readable by their owner.
+	if err := tmp.Chmod(0644); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), p)
+}
+
+// PutManifest stores the payload of a manifest as a blob and returns its
+// descriptor.
+func (l *Layout) PutManifest(m distribution.Manifest) (distribution.Descriptor, error) {
+	mediaType, payload, err := m.Payload()
+	if err != nil {
+		return distribution.Descriptor{}, err
+	}
+
+	desc := distribution.Descriptor{
+		MediaType: mediaType,
+		Digest:    digest.FromBytes(payload),
+		Size:      int64(len(payload)),
+	}
+	if !l.HasBlob(desc.Digest) {
+		if err := l.WriteBlob(desc.Digest, bytes.NewReader(payload)); err != nil {
+			return desc, err
+		}
+	}
+	return desc, nil
+}
+
+// AddManifest lists the manifest desc in the index under the name ref,
+// replacing a manifest of the same name. The manifest and everything it
+// references have to be stored in the layout first.
+func (l *Layout) AddManifest(desc distribution.Descriptor, ref string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if ref != "" {
+		annotations := map[string]string{}
+		for k, v := range desc.Annotations {
+			annotations[k] = v
+		}
+		annotations[ociv1.AnnotationRefName] = ref
+		desc.Annotations = annotations
+	}
+
+	manifests := make([]distribution.Descriptor, 0, len(l.index.Manifests)+1)
+	for _, m := range l.index.Manifests {
+		if ref != "" && m.Annotations[ociv1.AnnotationRefName] == ref {
+			continue
+		}
+		if ref == "" && m.Digest == desc.Digest && m.Annotations[ociv1.AnnotationRefName] == "" {
+			continue
+		}
+		manifests = append(manifests, m)
+	}
+	l.index.Manifests = append(manifests, desc)
+
+	b, err := json.MarshalIndent(l.index, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFile(filepath.Join(l.dir, indexFile), b)
+}
+
+// Manifest returns the descriptor of the manifest named ref in the index. A
+// ref can also be the digest of a listed manifest, and may be empty if the
+// index lists a single manifest.
+func (l *Layout) Manifest(ref string) (distribution.Descriptor, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if ref == "" {
+		if len(l.index.Manifests) == 1 {
+			return l.index.Manifests[0], nil
+		}
+		return distribution.Descriptor{}, fmt.Errorf("the layout lists %d manifests, pick one by name", len(l.index.Manifests))
+	}
+
+	for _, m := range l.index.Manifests {
+		if m.Annotations[ociv1.AnnotationRefName] == ref || m.Digest.String() == ref {
+			return m, nil
+		}
+	}
+	return distribution.Descriptor{}, fmt.Errorf("manifest %s: %w", ref, ErrNotFound)
+}
+
+// writeFile writes a file through a temporary file, so that readers never
+// see partial content.
+func writeFile(p string, b []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(p), ".tmp-"+filepath.Base(p))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	// Temporary files are only readable by their owner.
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), p)
+}
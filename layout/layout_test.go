@@ -0,0 +1,118 @@
+package layout
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+	digest "github.com/opencontainers/go-digest"
+	ociv1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestLayout(t *testing.T) {
+	dir := t.TempDir()
+
+	l, err := Create(dir)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	layer := "layer content"
+	layerDigest := digest.FromString(layer)
+	if err := l.WriteBlob(layerDigest, strings.NewReader(layer)); err != nil {
+		t.Fatalf("WriteBlob: %v", err)
+	}
+	if !l.HasBlob(layerDigest) {
+		t.Fatal("expected the layer to be stored")
+	}
+	fi, err := os.Stat(filepath.Join(dir, "blobs", "sha256", layerDigest.Encoded()))
+	if err != nil {
+		t.Fatalf("expected the layer at blobs/sha256/<hex>: %v", err)
+	}
+	if fi.Mode().Perm() != 0644 {
+		t.Errorf("expected the layer to be readable by everyone, got mode %s", fi.Mode())
+	}
+
+	// Content that does not match its digest is not stored.
+	bad := digest.FromString("other")
+	if err := l.WriteBlob(bad, strings.NewReader(layer)); err == nil {
+		t.Fatal("expected an error for content that does not match its digest")
+	}
+	if l.HasBlob(bad) {
+		t.Fatal("expected the mismatching blob not to be stored")
+	}
+
+	config := `{"architecture":"amd64","os":"linux"}`
+	configDigest := digest.FromString(config)
+	if err := l.WriteBlob(configDigest, strings.NewReader(config)); err != nil {
+		t.Fatalf("WriteBlob: %v", err)
+	}
+
+	m, err := ocischema.FromStruct(ocischema.Manifest{
+		Versioned: ocischema.SchemaVersion,
+		Config:    distribution.Descriptor{MediaType: ociv1.MediaTypeImageConfig, Digest: configDigest, Size: int64(len(config))},
+		Layers:    []distribution.Descriptor{{MediaType: ociv1.MediaTypeImageLayer, Digest: layerDigest, Size: int64(len(layer))}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	desc, err := l.PutManifest(m)
+	if err != nil {
+		t.Fatalf("PutManifest: %v", err)
+	}
+	if err := l.AddManifest(desc, "example.com/app:v1"); err != nil {
+		t.Fatalf("AddManifest: %v", err)
+	}
+	// Adding the same name again replaces the entry.
+	if err := l.AddManifest(desc, "example.com/app:v1"); err != nil {
+		t.Fatalf("AddManifest: %v", err)
+	}
+
+	if fi, err = os.Stat(filepath.Join(dir, "index.json")); err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0644 {
+		t.Errorf("expected index.json to be readable by everyone, got mode %s", fi.Mode())
+	}
+
+	// Reopen the layout from disk.
+	l, err = Open(dir)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if n := len(l.Index().Manifests); n != 1 {
+		t.Fatalf("expected a single manifest in the index, got %d", n)
+	}
+
+	found, err := l.Manifest("example.com/app:v1")
+	if err != nil {
+		t.Fatalf("Manifest: %v", err)
+	}
+	if found.Digest != desc.Digest || found.MediaType != ociv1.MediaTypeImageManifest {
+		t.Errorf("expected %v, got %v", desc, found)
+	}
+	if _, err := l.Manifest(""); err != nil {
+		t.Errorf("expected the only manifest for an empty ref, got %v", err)
+	}
+	if _, err := l.Manifest("example.com/app:v2"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+
+	b, err := l.ReadBlob(desc.Digest)
+	if err != nil {
+		t.Fatalf("ReadBlob: %v", err)
+	}
+	if digest.FromBytes(b) != desc.Digest {
+		t.Error("manifest payload does not match its digest")
+	}
+}
+
+func TestOpenNotALayout(t *testing.T) {
+	if _, err := Open(t.TempDir()); err == nil {
+		t.Fatal("expected an error for a directory without oci-layout")
+	}
+}
@@ -10,6 +10,9 @@ import (
 	"strings"
 	"sync"
 	"text/tabwriter"
+
+	"github.com/sirupsen/logrus"
+	"github.com/ttys3/reg/registry"
 )
 
 const listHelp = `List all repositories.`
@@ -20,9 +23,15 @@ func (cmd *listCommand) ShortHelp() string { return listHelp }
 func (cmd *listCommand) LongHelp() string  { return listHelp }
 func (cmd *listCommand) Hidden() bool      { return false }
 
-func (cmd *listCommand) Register(fs *flag.FlagSet) {}
+func (cmd *listCommand) Register(fs *flag.FlagSet) {
+	fs.IntVar(&cmd.pageSize, "page-size", 0, "number of repositories to request per catalog page, the registry default if 0")
+	fs.StringVar(&cmd.last, "last", "", "only list the repositories after this one, to resume an earlier listing")
+}
 
-type listCommand struct{}
+type listCommand struct {
+	pageSize int
+	last     string
+}
 
 func (cmd *listCommand) Run(ctx context.Context, args []string) error {
 	if len(args) < 1 {
@@ -34,56 +43,73 @@ func (cmd *listCommand) Run(ctx context.Context, args []string) error {
 	if err != nil {
 		return err
 	}
-	// Get the repositories via catalog.
-	repos, err := r.Catalog(ctx, "")
-	if err != nil {
-		if _, ok := err.(*json.SyntaxError); ok {
-			return fmt.Errorf("domain %s is not a valid registry", r.Domain)
+
+	// Setup the tab writer.
+	w := tabwriter.NewWriter(os.Stdout, 20, 1, 3, ' ', 0)
+	printedHeader := false
+	printHeader := func() {
+		if !printedHeader {
+			fmt.Printf("Repositories for %s\n", r.Domain)
+			fmt.Fprintln(w, "REPO\tTAGS")
+			printedHeader = true
 		}
-		return err
 	}
-	sort.Strings(repos)
-
-	fmt.Printf("Repositories for %s\n", r.Domain)
-
-	var (
-		l        sync.Mutex
-		wg       sync.WaitGroup
-		repoTags = map[string][]string{}
-	)
-
-	wg.Add(len(repos))
-	for _, repo := range repos {
-		go func(repo string) {
-			// Get the tags.
-			tags, err := r.Tags(ctx, repo)
-			if err != nil {
-				fmt.Printf("Get tags of [%s] error: %s", repo, err)
-			}
-			// Sort the tags
-			sort.Strings(tags)
 
-			// Lock on the write to the map.
-			l.Lock()
-			repoTags[repo] = tags
-			l.Unlock()
+	// Get the repositories via catalog, printing every page as it arrives.
+	pager := r.CatalogPager(cmd.pageSize, cmd.last)
+	for pager.Next(ctx) {
+		printHeader()
+		repos := pager.Page()
+		sort.Strings(repos)
+
+		var (
+			l        sync.Mutex
+			repoTags = map[string][]string{}
+		)
+
+		ex := registry.NewExecutor(r.Opt.Parallelism)
+		for _, repo := range repos {
+			repo := repo
+			ex.Go(ctx, repo, func(ctx context.Context) error {
+				// Get the tags.
+				tags, err := r.Tags(ctx, repo)
+				if err != nil {
+					return err
+				}
+				// Sort the tags
+				sort.Strings(tags)
+
+				// Lock on the write to the map.
+				l.Lock()
+				repoTags[repo] = tags
+				l.Unlock()
+				return nil
+			})
+		}
+		if err := ex.Wait(); err != nil {
+			for _, e := range err.(registry.Errors) {
+				logrus.Warnf("getting tags of %s failed: %v", e.Item, e.Err)
+			}
+		}
 
-			wg.Done()
-		}(repo)
+		for _, repo := range repos {
+			w.Write([]byte(fmt.Sprintf("%s\t%s\n", repo, strings.Join(repoTags[repo], ", "))))
+		}
+		w.Flush()
+		logrus.Debugf("listed repositories up to %s", pager.Last())
 	}
-	wg.Wait()
-
-	// Setup the tab writer.
-	w := tabwriter.NewWriter(os.Stdout, 20, 1, 3, ' ', 0)
 
-	// Print header.
-	fmt.Fprintln(w, "REPO\tTAGS")
-
-	// Sort the repos.
-	for _, repo := range repos {
-		w.Write([]byte(fmt.Sprintf("%s\t%s\n", repo, strings.Join(repoTags[repo], ", "))))
+	if err := pager.Err(); err != nil {
+		if _, ok := err.(*json.SyntaxError); ok {
+			return fmt.Errorf("domain %s is not a valid registry", r.Domain)
+		}
+		if last := pager.Last(); last != "" && last != cmd.last {
+			return fmt.Errorf("%w\nresume the listing with --last %s", err, last)
+		}
+		return err
 	}
 
+	printHeader()
 	w.Flush()
 
 	return nil
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"archive/tar"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/distribution/distribution/v3"
+	digest "github.com/opencontainers/go-digest"
+	"github.com/ttys3/reg/registry"
+)
+
+const lsFilesHelp = `List the files in the layers of an image.`
+
+func (cmd *lsFilesCommand) Name() string      { return "ls-files" }
+func (cmd *lsFilesCommand) Args() string      { return "[OPTIONS] NAME[:TAG|@DIGEST]" }
+func (cmd *lsFilesCommand) ShortHelp() string { return lsFilesHelp }
+func (cmd *lsFilesCommand) LongHelp() string {
+	return lsFilesHelp + "\n\nThe entries of every layer are listed from the base layer up, as they are stored in\nthe layer, including whiteout files. Layers are streamed, nothing is written to disk."
+}
+func (cmd *lsFilesCommand) Hidden() bool { return false }
+
+func (cmd *lsFilesCommand) Register(fs *flag.FlagSet) {
+	fs.StringVar(&cmd.layer, "layer", "", "only list the layer with this digest")
+}
+
+type lsFilesCommand struct {
+	layer string
+}
+
+func (cmd *lsFilesCommand) Run(ctx context.Context, args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("pass the name of the repository")
+	}
+
+	image, err := registry.ParseImage(args[0])
+	if err != nil {
+		return err
+	}
+
+	// Create the registry client.
+	r, err := createRegistryClient(ctx, image.Domain)
+	if err != nil {
+		return err
+	}
+
+	layers, err := cmd.layers(ctx, r, image)
+	if err != nil {
+		return err
+	}
+
+	for i, layer := range layers {
+		if len(layers) > 1 {
+			if i > 0 {
+				fmt.Println()
+			}
+			fmt.Printf("%s (%d bytes):\n", layer.Digest, layer.Size)
+		}
+
+		if err := walkLayer(ctx, r, image.Path, layer, func(hdr *tar.Header, _ io.Reader) error {
+			_, err := fmt.Println(formatEntry(hdr))
+			return err
+		}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// layers returns the layers of the image to list.
+func (cmd *lsFilesCommand) layers(ctx context.Context, r *registry.Registry, image registry.Image) ([]distribution.Descriptor, error) {
+	m, _, err := r.ResolveManifest(ctx, image.Path, image.Reference(), registry.Platform{})
+	if err != nil {
+		return nil, err
+	}
+	layers := imageLayers(m)
+	if cmd.layer == "" {
+		return layers, nil
+	}
+
+	d, err := digest.Parse(cmd.layer)
+	if err != nil {
+		return nil, err
+	}
+	for _, layer := range layers {
+		if layer.Digest == d {
+			return []distribution.Descriptor{layer}, nil
+		}
+	}
+	return nil, fmt.Errorf("layer %s is not part of %s", d, image.String())
+}
+
+// formatEntry formats a tar entry like tar -tv does: mode, owner, size and
+// path, followed by the target of links.
+func formatEntry(hdr *tar.Header) string {
+	owner := hdr.Uname
+	if owner == "" {
+		owner = strconv.Itoa(hdr.Uid)
+	}
+	group := hdr.Gname
+	if group == "" {
+		group = strconv.Itoa(hdr.Gid)
+	}
+
+	s := fmt.Sprintf("%s %-15s %10d %s", hdr.FileInfo().Mode(), owner+"/"+group, hdr.Size, hdr.Name)
+	switch hdr.Typeflag {
+	case tar.TypeSymlink:
+		s += " -> " + hdr.Linkname
+	case tar.TypeLink:
+		s += " link to " + hdr.Linkname
+	}
+	return s
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestLsFiles(t *testing.T) {
+	out, err := run("ls-files", fmt.Sprintf("%s/busybox:latest", domain))
+	if err != nil {
+		t.Fatalf("output: %s, error: %v", out, err)
+	}
+
+	if !strings.Contains(out, "bin/busybox") {
+		t.Fatalf("expected bin/busybox in the listing, got: %s", out)
+	}
+	if !strings.Contains(out, "drwxr-xr-x") {
+		t.Fatalf("expected directory modes in the listing, got: %s", out)
+	}
+}
@@ -24,6 +24,39 @@ var (
 
 	timeout time.Duration
 
+	retries         int
+	retryBackoff    time.Duration
+	retryMaxBackoff time.Duration
+
+	rateLimitReserve int
+
+	parallel int
+
+	cacheDir string
+
+	mirrors string
+
+	tlsCA    string
+	tlsCert  string
+	tlsKey   string
+	certsDir string
+
+	// configFile is the path of the per-registry config file, see
+	// repoutils.Config.
+	configFile     string
+	registryConfig repoutils.Config
+	// setFlags are the global flags given on the command line, which take
+	// precedence over the config file.
+	setFlags map[string]bool
+
+	// clients are the registry clients commands created.
+	clients []*registry.Registry
+
+	platform string
+	// selectsPlatforms is set by the commands that select the platforms of
+	// manifest lists themselves, which take a list of platforms.
+	selectsPlatforms bool
+
 	authURL  string
 	username string
 	password string
@@ -43,16 +76,32 @@ func main() {
 
 	// Build the list of available commands.
 	p.Commands = []cli.Command{
+		&cacheCommand{},
+		&catCommand{},
+		&cpCommand{},
 		&digestCommand{},
+		&exportCommand{},
 		&layerCommand{},
 		&listCommand{},
+		&lsFilesCommand{},
 		&manifestCommand{},
+		&platformsCommand{},
+		&pullCommand{},
+		&pushCommand{},
+		&ratelimitCommand{},
+		&referrersCommand{},
 		&removeCommand{},
+		&saveCommand{},
 		&serverCommand{},
 		&tagsCommand{},
 		&vulnsCommand{},
 	}
 
+	// Explain the registry errors commands return.
+	for i, cmd := range p.Commands {
+		p.Commands[i] = &explainCommand{cmd}
+	}
+
 	// Setup the global flags.
 	p.FlagSet = flag.NewFlagSet("global", flag.ExitOnError)
 	p.FlagSet.BoolVar(&insecure, "insecure", false, "do not verify tls certificates")
@@ -65,6 +114,27 @@ func main() {
 
 	p.FlagSet.DurationVar(&timeout, "timeout", time.Minute, "timeout for HTTP requests")
 
+	p.FlagSet.IntVar(&retries, "retries", 3, "number of times to retry rate limited or failed requests (0 disables retries)")
+	p.FlagSet.DurationVar(&retryBackoff, "retry-backoff", registry.DefaultRetryMinBackoff, "wait before the first retry, doubled on every attempt")
+	p.FlagSet.DurationVar(&retryMaxBackoff, "retry-max-backoff", registry.DefaultRetryMaxBackoff, "maximum wait between retries, longer Retry-After waits are not honored")
+
+	p.FlagSet.StringVar(&cacheDir, "cache-dir", "", "directory to cache manifests and blobs in, disabled if empty")
+
+	p.FlagSet.IntVar(&parallel, "parallel", registry.DefaultParallelism, "number of concurrent requests for bulk operations (ls, server, vulns) and blob downloads")
+
+	p.FlagSet.IntVar(&rateLimitReserve, "ratelimit-reserve", 0, "pause the manifest fetches of server index generation while the remaining pull quota is at or below this many pulls (0 disables pausing)")
+
+	p.FlagSet.StringVar(&platform, "platform", "", "platform to select from manifest lists (ex. linux/arm64/v8), defaults to linux/amd64 (cp, pull and push take a comma separated list)")
+
+	p.FlagSet.StringVar(&mirrors, "mirror", "", "comma separated mirrors to read from before the registry, as [REGISTRY=]URL, mirrors without a registry are used for docker.io")
+
+	p.FlagSet.StringVar(&tlsCA, "tls-ca", "", "PEM bundle of certificate authorities to trust in addition to the system roots")
+	p.FlagSet.StringVar(&tlsCert, "tls-cert", "", "PEM client certificate to present to the registry")
+	p.FlagSet.StringVar(&tlsKey, "tls-key", "", "PEM key of the client certificate")
+	p.FlagSet.StringVar(&certsDir, "certs-dir", registry.DefaultCertsDir, "directory with a <host> directory of *.crt CA certificates and *.cert/*.key client certificates per registry")
+
+	p.FlagSet.StringVar(&configFile, "config", repoutils.DefaultConfigPath(), "per-registry config file (credentials, tls, headers, mirrors, timeouts)")
+
 	p.FlagSet.StringVar(&authURL, "auth-url", "", "alternate URL for registry authentication (ex. auth.docker.io)")
 
 	p.FlagSet.StringVar(&username, "username", "", "username for the registry")
@@ -95,6 +165,19 @@ func main() {
 			logrus.SetLevel(logrus.DebugLevel)
 		}
 
+		// Load the per-registry config.
+		setFlags = map[string]bool{}
+		p.FlagSet.Visit(func(f *flag.Flag) {
+			setFlags[f.Name] = true
+		})
+		if configFile != "" {
+			c, err := repoutils.LoadConfig(configFile)
+			if err != nil {
+				return err
+			}
+			registryConfig = c
+		}
+
 		return nil
 	}
 
@@ -102,31 +185,139 @@ func main() {
 	p.Run()
 }
 
+// reportServed logs which endpoints served the reads of the registry clients,
+// so the use of mirrors can be checked.
+func reportServed() {
+	for _, r := range clients {
+		for endpoint, n := range r.Served() {
+			logrus.Infof("%s: %d requests served by %s", r.Domain, n, endpoint)
+		}
+	}
+}
+
 func createRegistryClient(ctx context.Context, domain string) (*registry.Registry, error) {
+	// Find the settings of the registry in the config file, flags take
+	// precedence over them.
+	rc, _ := registryConfig.Lookup(domain)
+
 	// Use the auth-url domain if provided.
 	authDomain := authURL
+	if authDomain == "" {
+		authDomain = rc.AuthURL
+	}
 	if authDomain == "" {
 		authDomain = domain
 	}
-	auth, err := repoutils.GetAuthConfig(username, password, authDomain)
+
+	// Use the configured credentials unless they were passed as flags.
+	user, pass := username, password
+	if user == "" && pass == "" {
+		var err error
+		user, pass, err = rc.Credentials.Resolve()
+		if err != nil {
+			return nil, fmt.Errorf("credentials of %s: %v", domain, err)
+		}
+	}
+	auth, err := repoutils.GetAuthConfig(user, pass, authDomain)
 	if err != nil {
 		return nil, err
 	}
 
+	nonSSL := forceNonSSL || rc.NonSSL
+	requestTimeout := timeout
+	if !setFlags["timeout"] && rc.Timeout > 0 {
+		requestTimeout = rc.Timeout
+	}
+
 	// Prevent non-ssl unless explicitly forced
-	if !forceNonSSL && strings.HasPrefix(auth.ServerAddress, "http:") {
+	if !nonSSL && strings.HasPrefix(auth.ServerAddress, "http:") {
 		return nil, fmt.Errorf("attempted to use insecure protocol! Use force-non-ssl option to force")
 	}
 
+	// Parse the platform to select from manifest lists. A list of platforms
+	// is only meaningful to the commands that select the platforms
+	// themselves, the client only gets a single platform.
+	var plat registry.Platform
+	platforms, err := registry.ParsePlatforms(platform)
+	if err != nil {
+		return nil, err
+	}
+	if len(platforms) > 1 && !selectsPlatforms {
+		return nil, fmt.Errorf("only cp, pull and push take more than one platform, got %q", platform)
+	}
+	if len(platforms) == 1 {
+		plat = platforms[0]
+	}
+
+	// Find the mirrors of the registry.
+	mirrorList, err := mirrorsFor(domain, mirrors)
+	if err != nil {
+		return nil, err
+	}
+	mirrorList = append(mirrorList, rc.Mirrors...)
+
 	// Create the registry client.
 	logrus.Infof("domain: %s", domain)
 	logrus.Infof("server address: %s", auth.ServerAddress)
-	return registry.New(ctx, auth, registry.Opt{
+	r, err := registry.New(ctx, auth, registry.Opt{
 		Domain:   domain,
-		Insecure: insecure,
+		Insecure: insecure || rc.Insecure,
 		Debug:    debug,
 		SkipPing: skipPing,
-		NonSSL:   forceNonSSL,
-		Timeout:  timeout,
+		NonSSL:   nonSSL,
+		Timeout:  requestTimeout,
+		Headers:  rc.Headers,
+		Platform: plat,
+		CAFile:   firstNonEmpty(tlsCA, rc.CA),
+		CertFile: firstNonEmpty(tlsCert, rc.Cert),
+		KeyFile:  firstNonEmpty(tlsKey, rc.Key),
+		CertsDir: certsDir,
+
+		MaxRetries:      retries,
+		RetryMinBackoff: retryBackoff,
+		RetryMaxBackoff: retryMaxBackoff,
+
+		RateLimitReserve: rateLimitReserve,
+		Parallelism:      parallel,
+		CacheDir:         cacheDir,
+		Mirrors:          mirrorList,
 	})
+	if err != nil {
+		return nil, err
+	}
+	clients = append(clients, r)
+	return r, nil
+}
+
+// mirrorsFor returns the mirrors configured for domain in a comma separated
+// list of [REGISTRY=]URL entries.
+func mirrorsFor(domain, list string) ([]string, error) {
+	var found []string
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		host, mirror := "docker.io", entry
+		if i := strings.Index(entry, "="); i >= 0 {
+			host, mirror = entry[:i], entry[i+1:]
+		}
+		if mirror == "" {
+			return nil, fmt.Errorf("invalid mirror %q, expected [REGISTRY=]URL", entry)
+		}
+		if repoutils.NormalizeDomain(host) == repoutils.NormalizeDomain(domain) {
+			found = append(found, mirror)
+		}
+	}
+	return found, nil
+}
+
+// firstNonEmpty returns the first of values that is not empty.
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
 }
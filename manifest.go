@@ -48,6 +48,12 @@ func (cmd *manifestCommand) Run(ctx context.Context, args []string) error {
 		if err != nil {
 			return err
 		}
+	} else if platform != "" {
+		// Get the manifest for the requested platform from a manifest list.
+		manifest, _, err = r.ResolveManifest(ctx, image.Path, image.Reference(), registry.Platform{})
+		if err != nil {
+			return err
+		}
 	} else {
 		// Get the v2 manifest.
 		manifest, _, err = r.Manifest(ctx, image.Path, image.Reference())
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/ttys3/reg/registry"
+)
+
+const platformsHelp = `List the platforms of a manifest list or OCI image index.`
+
+func (cmd *platformsCommand) Name() string      { return "platforms" }
+func (cmd *platformsCommand) Args() string      { return "[OPTIONS] NAME[:TAG|@DIGEST]" }
+func (cmd *platformsCommand) ShortHelp() string { return platformsHelp }
+func (cmd *platformsCommand) LongHelp() string  { return platformsHelp }
+func (cmd *platformsCommand) Hidden() bool      { return false }
+
+func (cmd *platformsCommand) Register(fs *flag.FlagSet) {}
+
+type platformsCommand struct{}
+
+func (cmd *platformsCommand) Run(ctx context.Context, args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("pass the name of the repository")
+	}
+
+	image, err := registry.ParseImage(args[0])
+	if err != nil {
+		return err
+	}
+
+	// Create the registry client.
+	r, err := createRegistryClient(ctx, image.Domain)
+	if err != nil {
+		return err
+	}
+
+	m, desc, err := r.Manifest(ctx, image.Path, image.Reference())
+	if err != nil {
+		return err
+	}
+
+	// Setup the tab writer.
+	w := tabwriter.NewWriter(os.Stdout, 20, 1, 3, ' ', 0)
+
+	// Print header.
+	fmt.Fprintln(w, "PLATFORM\tDIGEST\tSIZE\tMEDIA TYPE")
+
+	if !registry.IsManifestList(m) {
+		// A single manifest, the platform is only recorded in the image config.
+		var p registry.Platform
+		if err := r.GetConfig(ctx, image.Path, m.References()[0].Digest, &p); err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\n", p, desc.Digest, desc.Size, desc.MediaType)
+		return w.Flush()
+	}
+
+	for _, child := range m.References() {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\n", registry.DescriptorPlatform(child), child.Digest, child.Size, child.MediaType)
+	}
+
+	return w.Flush()
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/distribution/distribution/v3"
+	digest "github.com/opencontainers/go-digest"
+	"github.com/sirupsen/logrus"
+	"github.com/ttys3/reg/layout"
+	"github.com/ttys3/reg/registry"
+)
+
+const pullHelp = `Pull images into an OCI image layout directory.`
+
+func (cmd *pullCommand) Name() string      { return "pull" }
+func (cmd *pullCommand) Args() string      { return "[OPTIONS] NAME[:TAG|@DIGEST]..." }
+func (cmd *pullCommand) ShortHelp() string { return pullHelp }
+func (cmd *pullCommand) LongHelp() string {
+	return pullHelp + "\n\nThe whole manifest list or OCI index is pulled, or only the platforms passed with\n--platform. Images are named by their full reference in index.json."
+}
+func (cmd *pullCommand) Hidden() bool { return false }
+
+func (cmd *pullCommand) Register(fs *flag.FlagSet) {
+	fs.StringVar(&cmd.ociLayout, "oci-layout", "", "directory to write the images to in the OCI image layout format")
+}
+
+type pullCommand struct {
+	ociLayout string
+}
+
+func (cmd *pullCommand) Run(ctx context.Context, args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("pass the name of the image")
+	}
+	if cmd.ociLayout == "" {
+		return fmt.Errorf("pass the directory to pull to with --oci-layout")
+	}
+
+	// Only pull the requested platforms of a manifest list, all of them by default.
+	selectsPlatforms = true
+	platforms, err := registry.ParsePlatforms(platform)
+	if err != nil {
+		return err
+	}
+
+	l, err := layout.Create(cmd.ociLayout)
+	if err != nil {
+		return err
+	}
+
+	clients := map[string]*registry.Registry{}
+	for _, arg := range args {
+		image, err := registry.ParseImage(arg)
+		if err != nil {
+			return err
+		}
+
+		// Create the registry client, once per registry.
+		r, ok := clients[image.Domain]
+		if !ok {
+			if r, err = createRegistryClient(ctx, image.Domain); err != nil {
+				return err
+			}
+			clients[image.Domain] = r
+		}
+
+		p := &imagePuller{
+			r:         r,
+			repo:      image.Path,
+			layout:    l,
+			platforms: platforms,
+		}
+		desc, err := p.pullManifest(ctx, image.Reference())
+		if err != nil {
+			return err
+		}
+		if err := l.AddManifest(desc, image.String()); err != nil {
+			return err
+		}
+
+		fmt.Printf("Pulled %s to %s@%s\n", image.String(), cmd.ociLayout, desc.Digest)
+	}
+
+	return nil
+}
+
+// imagePuller writes manifests and the blobs they reference from a
+// repository into an image layout.
+type imagePuller struct {
+	r      *registry.Registry
+	repo   string
+	layout *layout.Layout
+
+	platforms []registry.Platform
+}
+
+// pullManifest pulls the manifest ref and everything it references. It
+// returns the descriptor of the manifest stored in the layout.
+func (p *imagePuller) pullManifest(ctx context.Context, ref string) (distribution.Descriptor, error) {
+	m, _, err := p.r.Manifest(ctx, p.repo, ref)
+	if err != nil {
+		return distribution.Descriptor{}, fmt.Errorf("getting manifest for %s:%s failed: %v", p.repo, ref, err)
+	}
+
+	// Manifests referenced by digest have to match it.
+	if d, err := digest.Parse(ref); err == nil {
+		if _, payload, err := m.Payload(); err != nil || d.Algorithm().FromBytes(payload) != d {
+			return distribution.Descriptor{}, fmt.Errorf("%w: manifest %s:%s", registry.ErrDigestMismatch, p.repo, ref)
+		}
+	}
+
+	if registry.IsManifestList(m) {
+		var children []distribution.Descriptor
+		if m, children, err = selectPlatforms(m, p.platforms); err != nil {
+			return distribution.Descriptor{}, err
+		}
+		for _, child := range children {
+			if _, err := p.pullManifest(ctx, child.Digest.String()); err != nil {
+				return distribution.Descriptor{}, err
+			}
+		}
+	} else {
+		// Download the config and layers concurrently.
+		ex := registry.NewExecutor(p.r.Opt.Parallelism)
+		for _, blob := range m.References() {
+			blob := blob
+			ex.Go(ctx, blob.Digest.String(), func(ctx context.Context) error {
+				return p.pullBlob(ctx, blob)
+			})
+		}
+		if err := ex.Wait(); err != nil {
+			return distribution.Descriptor{}, err
+		}
+	}
+
+	return p.layout.PutManifest(m)
+}
+
+// pullBlob writes a single blob into the layout unless it is already there.
+func (p *imagePuller) pullBlob(ctx context.Context, blob distribution.Descriptor) error {
+	if len(blob.URLs) > 0 {
+		// Foreign layers are not stored in the registry.
+		logrus.Infof("skipping foreign blob %s", blob.Digest)
+		return nil
+	}
+	if p.layout.HasBlob(blob.Digest) {
+		logrus.Debugf("blob %s already exists in %s, skipping", blob.Digest, p.layout.Dir())
+		return nil
+	}
+
+	logrus.Infof("pulling blob %s (%d bytes)", blob.Digest, blob.Size)
+	rc, err := p.r.FetchBlobReader(ctx, p.repo, blob)
+	if err != nil {
+		return fmt.Errorf("downloading blob %s failed: %v", blob.Digest, err)
+	}
+	defer rc.Close()
+
+	return p.layout.WriteBlob(blob.Digest, rc)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ttys3/reg/layout"
+)
+
+func TestPullOCILayout(t *testing.T) {
+	dir := t.TempDir()
+	image := fmt.Sprintf("%s/busybox:latest", domain)
+
+	out, err := run("pull", "--oci-layout", dir, image)
+	if err != nil {
+		t.Fatalf("output: %s, error: %v", out, err)
+	}
+	if !strings.Contains(out, "Pulled "+image) {
+		t.Fatalf("expected the image to be pulled, got: %s", out)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "oci-layout")); err != nil {
+		t.Fatalf("expected an oci-layout file: %v", err)
+	}
+
+	l, err := layout.Open(dir)
+	if err != nil {
+		t.Fatalf("opening the layout failed: %v", err)
+	}
+	desc, err := l.Manifest(image)
+	if err != nil {
+		t.Fatalf("expected %s in index.json: %v", image, err)
+	}
+
+	// Every blob the manifest references is in the layout.
+	b, err := l.ReadBlob(desc.Digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m struct {
+		Config struct {
+			Digest string `json:"digest"`
+		} `json:"config"`
+		Layers []struct {
+			Digest string `json:"digest"`
+		} `json:"layers"`
+	}
+	if err := json.Unmarshal(b, &m); err != nil {
+		t.Fatal(err)
+	}
+	if m.Config.Digest == "" || len(m.Layers) == 0 {
+		t.Fatalf("expected an image manifest, got %s", b)
+	}
+	for _, layer := range m.Layers {
+		if _, err := os.Stat(filepath.Join(dir, "blobs", strings.Replace(layer.Digest, ":", "/", 1))); err != nil {
+			t.Errorf("expected layer %s in the layout: %v", layer.Digest, err)
+		}
+	}
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/manifest/schema2"
+	digest "github.com/opencontainers/go-digest"
+	ociv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/sirupsen/logrus"
+	"github.com/ttys3/reg/layout"
+	"github.com/ttys3/reg/registry"
+)
+
+const pushHelp = `Push an image from an OCI image layout or a docker save tarball.`
+
+func (cmd *pushCommand) Name() string      { return "push" }
+func (cmd *pushCommand) Args() string      { return "[OPTIONS] SRC DST_NAME[:TAG]" }
+func (cmd *pushCommand) ShortHelp() string { return pushHelp }
+func (cmd *pushCommand) LongHelp() string {
+	return pushHelp + "\n\nSRC is an OCI image layout directory, as written by reg pull, or a tarball written by\ndocker save or podman save. If SRC holds more than one image, pick one with --ref.\nA schema2 manifest is generated for images from a tarball, with uncompressed layers\ngzip compressed."
+}
+func (cmd *pushCommand) Hidden() bool { return false }
+
+func (cmd *pushCommand) Register(fs *flag.FlagSet) {
+	fs.StringVar(&cmd.ref, "ref", "", "image to push from SRC, a name from index.json or a tag from the manifest.json of a tarball")
+}
+
+type pushCommand struct {
+	ref string
+}
+
+func (cmd *pushCommand) Run(ctx context.Context, args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("pass the source and the name of the destination repository")
+	}
+
+	// Only push the requested platforms of a manifest list, all of them by default.
+	selectsPlatforms = true
+	platforms, err := registry.ParsePlatforms(platform)
+	if err != nil {
+		return err
+	}
+
+	src := args[0]
+	fi, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+
+	dst, err := registry.ParseImage(args[1])
+	if err != nil {
+		return err
+	}
+
+	// Create the registry client.
+	r, err := createRegistryClient(ctx, dst.Domain)
+	if err != nil {
+		return err
+	}
+
+	p := &imagePusher{
+		r:         r,
+		repo:      dst.Path,
+		platforms: platforms,
+		pushed:    map[digest.Digest]bool{},
+	}
+
+	var d digest.Digest
+	if fi.IsDir() {
+		d, err = p.pushLayout(ctx, src, cmd.ref, dst.Reference())
+	} else {
+		d, err = p.pushArchive(ctx, src, cmd.ref, dst.Reference())
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Pushed %s to %s@%s\n", src, dst.String(), d)
+
+	return nil
+}
+
+// imagePusher uploads manifests and the blobs they reference from an image
+// layout or a docker archive into a repository.
+type imagePusher struct {
+	r    *registry.Registry
+	repo string
+
+	platforms []registry.Platform
+
+	mu     sync.Mutex
+	pushed map[digest.Digest]bool
+}
+
+// pushLayout pushes the manifest named ref in the image layout dir as dstRef.
+func (p *imagePusher) pushLayout(ctx context.Context, dir, ref, dstRef string) (digest.Digest, error) {
+	l, err := layout.Open(dir)
+	if err != nil {
+		return "", err
+	}
+	desc, err := l.Manifest(ref)
+	if err != nil {
+		return "", err
+	}
+	return p.pushLayoutManifest(ctx, l, desc, dstRef)
+}
+
+// pushLayoutManifest pushes the manifest desc from the layout and everything
+// it references. It returns the digest of the pushed manifest.
+func (p *imagePusher) pushLayoutManifest(ctx context.Context, l *layout.Layout, desc distribution.Descriptor, dstRef string) (digest.Digest, error) {
+	payload, err := l.ReadBlob(desc.Digest)
+	if err != nil {
+		return "", err
+	}
+	mediaType := desc.MediaType
+	if mediaType == "" {
+		mediaType = manifestMediaType(payload)
+	}
+	m, _, err := distribution.UnmarshalManifest(mediaType, payload)
+	if err != nil {
+		return "", fmt.Errorf("parsing manifest %s failed: %v", desc.Digest, err)
+	}
+
+	if registry.IsManifestList(m) {
+		var children []distribution.Descriptor
+		if m, children, err = selectPlatforms(m, p.platforms); err != nil {
+			return "", err
+		}
+		for _, child := range children {
+			if _, err := p.pushLayoutManifest(ctx, l, child, child.Digest.String()); err != nil {
+				return "", err
+			}
+		}
+	} else {
+		// Upload the config and layers concurrently.
+		ex := registry.NewExecutor(p.r.Opt.Parallelism)
+		for _, blob := range m.References() {
+			blob := blob
+			ex.Go(ctx, blob.Digest.String(), func(ctx context.Context) error {
+				return p.pushBlob(ctx, blob, func() (io.ReadCloser, error) {
+					return l.Blob(blob.Digest)
+				})
+			})
+		}
+		if err := ex.Wait(); err != nil {
+			return "", err
+		}
+	}
+
+	// The payload is pushed unchanged unless platforms were dropped from a list.
+	return p.putManifest(ctx, dstRef, m)
+}
+
+// pushArchive pushes the image tagged ref in the docker archive at path as
+// dstRef.
+func (p *imagePusher) pushArchive(ctx context.Context, path, ref, dstRef string) (digest.Digest, error) {
+	a, err := layout.OpenArchive(path)
+	if err != nil {
+		return "", err
+	}
+	defer a.Close()
+
+	logrus.Infof("hashing the layers of %s", path)
+	m, files, err := a.Image(ref)
+	if err != nil {
+		return "", err
+	}
+
+	open := map[digest.Digest]func() (io.ReadCloser, error){}
+	for d, name := range files {
+		name := name
+		open[d] = func() (io.ReadCloser, error) {
+			sr, err := a.Open(name)
+			if err != nil {
+				return nil, err
+			}
+			return io.NopCloser(sr), nil
+		}
+	}
+
+	// Registries expect compressed layers, and docker save stores them
+	// uncompressed, so compress them before the upload. The diff_ids of the
+	// config stay the same, they are the digests of the uncompressed layers.
+	dir, err := os.MkdirTemp("", "reg-push-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+
+	layers := append([]distribution.Descriptor{}, m.Layers...)
+	compressed := make([]string, len(layers))
+	ex := registry.NewExecutor(p.r.Opt.Parallelism)
+	for i, layer := range layers {
+		if layer.MediaType != schema2.MediaTypeUncompressedLayer {
+			continue
+		}
+		i, layer := i, layer
+		ex.Go(ctx, layer.Digest.String(), func(ctx context.Context) error {
+			logrus.Infof("compressing layer %s", layer.Digest)
+			desc, name, err := compressLayer(dir, open[layer.Digest])
+			if err != nil {
+				return fmt.Errorf("compressing layer %s failed: %v", layer.Digest, err)
+			}
+			layers[i], compressed[i] = desc, name
+			return nil
+		})
+	}
+	if err := ex.Wait(); err != nil {
+		return "", err
+	}
+	for i, name := range compressed {
+		if name != "" {
+			name := name
+			open[layers[i].Digest] = func() (io.ReadCloser, error) {
+				return os.Open(name)
+			}
+		}
+	}
+	if m, err = schema2.FromStruct(schema2.Manifest{
+		Versioned: schema2.SchemaVersion,
+		Config:    m.Config,
+		Layers:    layers,
+	}); err != nil {
+		return "", err
+	}
+
+	ex = registry.NewExecutor(p.r.Opt.Parallelism)
+	for _, blob := range m.References() {
+		blob := blob
+		ex.Go(ctx, blob.Digest.String(), func(ctx context.Context) error {
+			return p.pushBlob(ctx, blob, open[blob.Digest])
+		})
+	}
+	if err := ex.Wait(); err != nil {
+		return "", err
+	}
+
+	return p.putManifest(ctx, dstRef, m)
+}
+
+// compressLayer writes the gzip compressed content of a layer into a file in
+// dir. It returns the descriptor of the compressed layer and the file name.
+func compressLayer(dir string, open func() (io.ReadCloser, error)) (distribution.Descriptor, string, error) {
+	rc, err := open()
+	if err != nil {
+		return distribution.Descriptor{}, "", err
+	}
+	defer rc.Close()
+
+	f, err := os.CreateTemp(dir, "layer-")
+	if err != nil {
+		return distribution.Descriptor{}, "", err
+	}
+	defer f.Close()
+
+	digester := digest.Canonical.Digester()
+	zw := gzip.NewWriter(io.MultiWriter(f, digester.Hash()))
+	if _, err := io.Copy(zw, rc); err != nil {
+		return distribution.Descriptor{}, "", err
+	}
+	if err := zw.Close(); err != nil {
+		return distribution.Descriptor{}, "", err
+	}
+	size, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return distribution.Descriptor{}, "", err
+	}
+
+	return distribution.Descriptor{
+		MediaType: schema2.MediaTypeLayer,
+		Digest:    digester.Digest(),
+		Size:      size,
+	}, f.Name(), f.Close()
+}
+
+func (p *imagePusher) putManifest(ctx context.Context, ref string, m distribution.Manifest) (digest.Digest, error) {
+	logrus.Infof("pushing manifest %s:%s", p.repo, ref)
+	d, err := p.r.PutManifest(ctx, p.repo, ref, m)
+	if err != nil {
+		return "", fmt.Errorf("pushing manifest %s:%s failed: %v", p.repo, ref, err)
+	}
+	return d, nil
+}
+
+// pushBlob uploads a single blob unless the repository already has it. The
+// content is verified against the descriptor while it is uploaded.
+func (p *imagePusher) pushBlob(ctx context.Context, blob distribution.Descriptor, open func() (io.ReadCloser, error)) error {
+	p.mu.Lock()
+	done := p.pushed[blob.Digest]
+	p.mu.Unlock()
+	if done {
+		return nil
+	}
+
+	if len(blob.URLs) > 0 {
+		// Foreign layers are not stored in the registry.
+		logrus.Infof("skipping foreign blob %s", blob.Digest)
+		return nil
+	}
+
+	// A mirror may have the blob even if the repository does not.
+	exists, err := p.r.HasLayer(registry.WithoutMirrors(ctx), p.repo, blob.Digest)
+	if err != nil {
+		return err
+	}
+	if exists {
+		logrus.Infof("blob %s already exists in %s, skipping", blob.Digest, p.repo)
+	} else {
+		logrus.Infof("pushing blob %s (%d bytes)", blob.Digest, blob.Size)
+		verified := func() (io.ReadCloser, error) {
+			rc, err := open()
+			if err != nil {
+				return nil, err
+			}
+			v, err := registry.VerifyReader(rc, blob.Digest, blob.Size)
+			if err != nil {
+				rc.Close()
+				return nil, err
+			}
+			return v, nil
+		}
+		if err := p.r.UploadBlob(ctx, p.repo, blob, verified); err != nil {
+			return fmt.Errorf("uploading blob %s failed: %v", blob.Digest, err)
+		}
+	}
+
+	p.mu.Lock()
+	p.pushed[blob.Digest] = true
+	p.mu.Unlock()
+	return nil
+}
+
+// manifestMediaType returns the media type a manifest payload declares,
+// for index.json entries that leave it out. Manifests without one are OCI
+// image manifests, or OCI indexes if they list manifests.
+func manifestMediaType(payload []byte) string {
+	var m struct {
+		MediaType string            `json:"mediaType"`
+		Manifests []json.RawMessage `json:"manifests"`
+	}
+	json.Unmarshal(payload, &m)
+	if m.MediaType != "" {
+		return m.MediaType
+	}
+	if m.Manifests != nil {
+		return ociv1.MediaTypeImageIndex
+	}
+	return ociv1.MediaTypeImageManifest
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/manifest/schema2"
+	digest "github.com/opencontainers/go-digest"
+	"github.com/ttys3/reg/layout"
+)
+
+func TestPushOCILayout(t *testing.T) {
+	dir := t.TempDir()
+	image := fmt.Sprintf("%s/busybox:latest", domain)
+
+	out, err := run("pull", "--oci-layout", dir, image)
+	if err != nil {
+		t.Fatalf("output: %s, error: %v", out, err)
+	}
+
+	dst := fmt.Sprintf("%s/busybox:pushed", domain)
+	out, err = run("push", dir, dst)
+	if err != nil {
+		t.Fatalf("output: %s, error: %v", out, err)
+	}
+	if !strings.Contains(out, "Pushed "+dir+" to "+dst) {
+		t.Fatalf("expected the image to be pushed, got: %s", out)
+	}
+
+	// The pushed image has the same digest as the one it was pulled from.
+	src, err := run("digest", image)
+	if err != nil {
+		t.Fatalf("output: %s, error: %v", src, err)
+	}
+	pushed, err := run("digest", dst)
+	if err != nil {
+		t.Fatalf("output: %s, error: %v", pushed, err)
+	}
+	if strings.TrimSpace(src) != strings.TrimSpace(pushed) {
+		t.Fatalf("expected digest %s, got %s", src, pushed)
+	}
+}
+
+func TestPushArchiveCompressesLayers(t *testing.T) {
+	// Build a tarball like docker save does, with an uncompressed layer.
+	var layer bytes.Buffer
+	tw := tar.NewWriter(&layer)
+	content := []byte("hello from an uncompressed layer\n")
+	tw.WriteHeader(&tar.Header{Name: "hello.txt", Mode: 0644, Size: int64(len(content))})
+	tw.Write(content)
+	tw.Close()
+	layerDesc := distribution.Descriptor{Digest: digest.FromBytes(layer.Bytes()), Size: int64(layer.Len())}
+
+	config := []byte(`{"architecture":"amd64","os":"linux","rootfs":{"type":"layers","diff_ids":["` + layerDesc.Digest.String() + `"]}}`)
+	configDesc := distribution.Descriptor{Digest: digest.FromBytes(config), Size: int64(len(config))}
+
+	archive := filepath.Join(t.TempDir(), "image.tar")
+	f, err := os.Create(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := layout.NewArchiveWriter(f)
+	if err := w.WriteBlob(configDesc, bytes.NewReader(config)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteBlob(layerDesc, bytes.NewReader(layer.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.AddImage(configDesc, []distribution.Descriptor{layerDesc}, []string{"uncompressed:latest"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := fmt.Sprintf("%s/uncompressed:latest", domain)
+	out, err := run("push", archive, dst)
+	if err != nil {
+		t.Fatalf("output: %s, error: %v", out, err)
+	}
+
+	out, err = run("manifest", dst)
+	if err != nil {
+		t.Fatalf("output: %s, error: %v", out, err)
+	}
+	if !strings.Contains(out, `"`+schema2.MediaTypeLayer+`"`) || strings.Contains(out, `"`+schema2.MediaTypeUncompressedLayer+`"`) {
+		t.Fatalf("expected the layer to be pushed gzip compressed, got: %s", out)
+	}
+
+	// The file is still readable from the pushed image.
+	out, err = run("cat", dst, "hello.txt")
+	if err != nil {
+		t.Fatalf("output: %s, error: %v", out, err)
+	}
+	if !strings.Contains(out, string(content)) {
+		t.Fatalf("expected the content of hello.txt, got: %s", out)
+	}
+}
+
+func TestPushThroughMirror(t *testing.T) {
+	// A pull-through mirror keeps the blobs of every repository, so it has
+	// the blobs of busybox even for a repository that does not.
+	var (
+		mu   sync.Mutex
+		reqs []string
+	)
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		reqs = append(reqs, r.Method+" "+r.URL.Path)
+		mu.Unlock()
+		if strings.Contains(r.URL.Path, "/blobs/") {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer mirror.Close()
+
+	dir := t.TempDir()
+	out, err := run("pull", "--oci-layout", dir, fmt.Sprintf("%s/busybox:latest", domain))
+	if err != nil {
+		t.Fatalf("output: %s, error: %v", out, err)
+	}
+
+	// The blobs have to be uploaded, or the registry rejects the manifest.
+	dst := fmt.Sprintf("%s/mirrored:latest", domain)
+	out, err = run("push", "--mirror", domain+"="+mirror.URL, dir, dst)
+	if err != nil {
+		t.Fatalf("output: %s, error: %v", out, err)
+	}
+	if strings.Contains(out, "already exists") {
+		t.Fatalf("expected the blobs to be uploaded, got: %s", out)
+	}
+
+	out, err = run("ls", "--mirror", domain+"="+mirror.URL, domain)
+	if err != nil {
+		t.Fatalf("output: %s, error: %v", out, err)
+	}
+	if !strings.Contains(out, "mirrored") {
+		t.Fatalf("expected the catalog of the registry, got: %s", out)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for _, req := range reqs {
+		if strings.HasSuffix(req, "/_catalog") {
+			t.Fatalf("expected the catalog not to be read from the mirror, got %s", req)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/ttys3/reg/registry"
+)
+
+const ratelimitHelp = `Show the remaining pull quota of a registry.`
+
+// defaultRateLimitImage is the image Docker suggests for checking the quota.
+const defaultRateLimitImage = "ratelimitpreview/test:latest"
+
+func (cmd *ratelimitCommand) Name() string      { return "ratelimit" }
+func (cmd *ratelimitCommand) Args() string      { return "[OPTIONS] [NAME[:TAG|@DIGEST]]" }
+func (cmd *ratelimitCommand) ShortHelp() string { return ratelimitHelp }
+func (cmd *ratelimitCommand) LongHelp() string {
+	return ratelimitHelp + "\n\nThe manifest is only requested with HEAD, which does not count against the quota.\nDefaults to " + defaultRateLimitImage + "."
+}
+func (cmd *ratelimitCommand) Hidden() bool { return false }
+
+func (cmd *ratelimitCommand) Register(fs *flag.FlagSet) {}
+
+type ratelimitCommand struct{}
+
+func (cmd *ratelimitCommand) Run(ctx context.Context, args []string) error {
+	name := defaultRateLimitImage
+	if len(args) > 0 {
+		name = args[0]
+	}
+
+	image, err := registry.ParseImage(name)
+	if err != nil {
+		return err
+	}
+
+	// Create the registry client.
+	r, err := createRegistryClient(ctx, image.Domain)
+	if err != nil {
+		return err
+	}
+
+	if _, err := r.HeadManifest(ctx, image.Path, image.Reference()); err != nil {
+		return err
+	}
+
+	limit, ok := r.RateLimit()
+	if !ok {
+		fmt.Printf("%s does not report a rate limit\n", r.Domain)
+		return nil
+	}
+
+	fmt.Printf("Remaining: %d\n", limit.Remaining)
+	fmt.Printf("Limit: %d\n", limit.Limit)
+	if limit.Window > 0 {
+		fmt.Printf("Window: %s\n", limit.Window)
+	}
+	if limit.Source != "" {
+		fmt.Printf("Source: %s\n", limit.Source)
+	}
+
+	return nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+
+	digest "github.com/opencontainers/go-digest"
+	"github.com/ttys3/reg/registry"
+)
+
+const referrersHelp = `Show the tree of artifacts (signatures, SBOMs, attestations) attached to an image.`
+
+func (cmd *referrersCommand) Name() string      { return "referrers" }
+func (cmd *referrersCommand) Args() string      { return "[OPTIONS] NAME[:TAG|@DIGEST]" }
+func (cmd *referrersCommand) ShortHelp() string { return referrersHelp }
+func (cmd *referrersCommand) LongHelp() string  { return referrersHelp }
+func (cmd *referrersCommand) Hidden() bool      { return false }
+
+func (cmd *referrersCommand) Register(fs *flag.FlagSet) {
+	fs.StringVar(&cmd.artifactType, "artifact-type", "", "only show direct referrers of this artifact type")
+}
+
+type referrersCommand struct {
+	artifactType string
+}
+
+func (cmd *referrersCommand) Run(ctx context.Context, args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("pass the name of the repository")
+	}
+
+	image, err := registry.ParseImage(args[0])
+	if err != nil {
+		return err
+	}
+
+	// Create the registry client.
+	r, err := createRegistryClient(ctx, image.Domain)
+	if err != nil {
+		return err
+	}
+
+	// Get the digest of the manifest the artifacts refer to.
+	_, desc, err := r.Manifest(ctx, image.Path, image.Reference())
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%s@%s\n", image.Path, desc.Digest)
+
+	visited := map[digest.Digest]bool{desc.Digest: true}
+	return cmd.printTree(ctx, r, image.Path, desc.Digest, cmd.artifactType, "", visited)
+}
+
+// printTree prints the referrers of dgst, and recursively their referrers.
+func (cmd *referrersCommand) printTree(ctx context.Context, r *registry.Registry, repo string, dgst digest.Digest, artifactType, prefix string, visited map[digest.Digest]bool) error {
+	referrers, err := r.Referrers(ctx, repo, dgst, artifactType)
+	if err != nil {
+		return fmt.Errorf("getting referrers for %s@%s failed: %v", repo, dgst, err)
+	}
+
+	for i, ref := range referrers {
+		branch, indent := "├── ", "│   "
+		if i == len(referrers)-1 {
+			branch, indent = "└── ", "    "
+		}
+
+		kind := ref.ArtifactType
+		if kind == "" {
+			kind = ref.MediaType
+		}
+		fmt.Printf("%s%s%s %s\n", prefix, branch, ref.Digest, strings.TrimSpace(kind))
+
+		if visited[ref.Digest] {
+			continue
+		}
+		visited[ref.Digest] = true
+
+		// Artifacts can be attached to artifacts, e.g. a signature of an SBOM.
+		if err := cmd.printTree(ctx, r, repo, ref.Digest, "", prefix+indent, visited); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
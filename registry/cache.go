@@ -0,0 +1,346 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	digest "github.com/opencontainers/go-digest"
+)
+
+// Cache is an on-disk store for manifests and blobs keyed by their digest.
+// Content addressed by digest is immutable, so entries never have to be
+// revalidated. Tags only point at a digest and are revalidated with a
+// conditional HEAD request before the cached manifest is used.
+//
+// The cache directory is laid out as:
+//
+//	manifests/<algorithm>/<hex>   media type and payload of a manifest
+//	blobs/<algorithm>/<hex>       content of a blob
+//	tags/<domain>/<repository>/<tag>  digest a tag pointed at
+//
+// Domains and repositories are escaped into a single path component, so that
+// the tag bar of foo and the repository foo/bar do not share a path.
+type Cache struct {
+	dir string
+}
+
+// cachedManifest is the file format of a cached manifest. The payload is kept
+// as bytes so its digest is preserved.
+type cachedManifest struct {
+	MediaType string `json:"mediaType"`
+	Payload   []byte `json:"payload"`
+}
+
+// NewCache returns a cache stored in dir, creating the directory if needed.
+func NewCache(dir string) (*Cache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("creating cache directory %s failed: %v", dir, err)
+	}
+	return &Cache{dir: dir}, nil
+}
+
+// Dir returns the directory of the cache.
+func (c *Cache) Dir() string {
+	return c.dir
+}
+
+func (c *Cache) path(kind string, d digest.Digest) string {
+	return filepath.Join(c.dir, kind, d.Algorithm().String(), d.Encoded())
+}
+
+func (c *Cache) tagPath(domain, repository, tag string) string {
+	return filepath.Join(c.dir, "tags", url.PathEscape(domain), url.PathEscape(repository), tag)
+}
+
+// Manifest returns the media type and payload of a cached manifest.
+func (c *Cache) Manifest(d digest.Digest) (string, []byte, bool) {
+	if d.Validate() != nil {
+		return "", nil, false
+	}
+
+	p := c.path("manifests", d)
+	b, err := os.ReadFile(p)
+	if err != nil {
+		return "", nil, false
+	}
+
+	var m cachedManifest
+	if err := json.Unmarshal(b, &m); err != nil {
+		return "", nil, false
+	}
+	if d.Algorithm().FromBytes(m.Payload) != d {
+		// The entry is corrupt, drop it.
+		os.Remove(p)
+		return "", nil, false
+	}
+
+	touch(p)
+	return m.MediaType, m.Payload, true
+}
+
+// PutManifest stores a manifest under its digest. The payload has to match d.
+func (c *Cache) PutManifest(d digest.Digest, mediaType string, payload []byte) error {
+	if err := d.Validate(); err != nil {
+		return err
+	}
+	if d.Algorithm().FromBytes(payload) != d {
+		return fmt.Errorf("%w: manifest payload does not match %s", ErrDigestMismatch, d)
+	}
+
+	b, err := json.Marshal(cachedManifest{MediaType: mediaType, Payload: payload})
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(c.path("manifests", d), b)
+}
+
+// Blob opens a cached blob.
+func (c *Cache) Blob(d digest.Digest) (*os.File, bool) {
+	if d.Validate() != nil {
+		return nil, false
+	}
+
+	p := c.path("blobs", d)
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, false
+	}
+
+	touch(p)
+	return f, true
+}
+
+// CacheBlob returns a reader that copies what is read from rc into the
+// cache. rc has to verify its content, like the reader returned by
+// VerifyReader does: the blob is only committed to the cache once rc returned
+// io.EOF, and discarded if reading fails or stops early.
+func (c *Cache) CacheBlob(rc io.ReadCloser, d digest.Digest) io.ReadCloser {
+	if d.Validate() != nil {
+		return rc
+	}
+
+	p := c.path("blobs", d)
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return rc
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(p), ".tmp-"+d.Encoded())
+	if err != nil {
+		return rc
+	}
+	return &cachingReader{rc: rc, tmp: tmp, path: p}
+}
+
+// Tag returns the digest a tag pointed at when it was cached.
+func (c *Cache) Tag(domain, repository, tag string) (digest.Digest, bool) {
+	b, err := os.ReadFile(c.tagPath(domain, repository, tag))
+	if err != nil {
+		return "", false
+	}
+	d, err := digest.Parse(strings.TrimSpace(string(b)))
+	if err != nil {
+		return "", false
+	}
+	return d, true
+}
+
+// PutTag records the digest a tag points at.
+func (c *Cache) PutTag(domain, repository, tag string, d digest.Digest) error {
+	return writeFileAtomic(c.tagPath(domain, repository, tag), []byte(d.String()+"\n"))
+}
+
+// Prune removes cache entries that were not used for longer than maxAge, and
+// then the least recently used entries until the cache is no larger than
+// maxSize bytes. A zero maxAge or maxSize disables that limit. It returns the
+// number of removed entries and the bytes they took.
+func (c *Cache) Prune(maxSize int64, maxAge time.Duration) (int, int64, error) {
+	type entry struct {
+		path    string
+		size    int64
+		modTime time.Time
+	}
+
+	var (
+		entries []entry
+		total   int64
+	)
+	err := filepath.WalkDir(c.dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		entries = append(entries, entry{path: p, size: info.Size(), modTime: info.ModTime()})
+		total += info.Size()
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// Oldest first.
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].modTime.Before(entries[j].modTime)
+	})
+
+	var (
+		removed int
+		freed   int64
+	)
+	now := time.Now()
+	for _, e := range entries {
+		expired := maxAge > 0 && now.Sub(e.modTime) > maxAge
+		tooLarge := maxSize > 0 && total > maxSize
+		if !expired && !tooLarge {
+			continue
+		}
+		if err := os.Remove(e.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return removed, freed, err
+		}
+		removed++
+		freed += e.size
+		total -= e.size
+	}
+
+	return removed, freed, nil
+}
+
+// cachingReader writes what is read into a temporary file, which is moved
+// into the cache once the whole blob was read and verified.
+type cachingReader struct {
+	rc   io.ReadCloser
+	tmp  *os.File
+	path string
+}
+
+func (r *cachingReader) Read(p []byte) (int, error) {
+	n, err := r.rc.Read(p)
+	if r.tmp == nil {
+		return n, err
+	}
+
+	if n > 0 {
+		if _, werr := r.tmp.Write(p[:n]); werr != nil {
+			r.discard()
+		}
+	}
+
+	switch {
+	case err == io.EOF:
+		r.commit()
+	case err != nil:
+		r.discard()
+	}
+	return n, err
+}
+
+func (r *cachingReader) Close() error {
+	r.discard()
+	return r.rc.Close()
+}
+
+func (r *cachingReader) commit() {
+	tmp := r.tmp
+	r.tmp = nil
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return
+	}
+	if err := os.Rename(tmp.Name(), r.path); err != nil {
+		os.Remove(tmp.Name())
+	}
+}
+
+func (r *cachingReader) discard() {
+	if r.tmp == nil {
+		return
+	}
+	r.tmp.Close()
+	os.Remove(r.tmp.Name())
+	r.tmp = nil
+}
+
+// cachedTagDigest returns the digest of a tag from the cache if the registry
+// confirms with a conditional HEAD request that the tag still points at it.
+func (r *Registry) cachedTagDigest(ctx context.Context, repository, tag string) (digest.Digest, bool) {
+	cached, ok := r.cache.Tag(r.Domain, repository, tag)
+	if !ok {
+		return "", false
+	}
+
+	uri := r.url("/v2/%s/manifests/%s", repository, tag)
+	req, err := http.NewRequest("HEAD", uri, nil)
+	if err != nil {
+		return "", false
+	}
+	req.Header.Add("Accept", strings.Join(ManifestSupportedSchemeTypes, ","))
+	req.Header.Set("If-None-Match", `"`+cached.String()+`"`)
+
+	resp, err := r.Client.Do(req.WithContext(ctx))
+	if err != nil {
+		r.Logf("registry.cache.revalidate repository=%s tag=%s err=%v", repository, tag, err)
+		return "", false
+	}
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		r.Logf("registry.cache.revalidate repository=%s tag=%s digest=%s not modified", repository, tag, cached)
+		return cached, true
+	case http.StatusOK:
+		if d := resp.Header.Get("Docker-Content-Digest"); d == cached.String() {
+			r.Logf("registry.cache.revalidate repository=%s tag=%s digest=%s unchanged", repository, tag, cached)
+			return cached, true
+		}
+	}
+	return "", false
+}
+
+func touch(p string) {
+	now := time.Now()
+	os.Chtimes(p, now, now)
+}
+
+// writeFileAtomic writes a file through a temporary file, so that readers
+// never see partial content.
+func writeFileAtomic(p string, b []byte) error {
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(p), ".tmp-"+filepath.Base(p))
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), p); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	digest "github.com/opencontainers/go-digest"
+	ociv1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestCache(t *testing.T) {
+	var (
+		manifests = map[string][]byte{
+			"v1": []byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json","config":{"mediaType":"application/vnd.oci.image.config.v1+json","digest":"sha256:44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a","size":2},"layers":[]}`),
+			"v2": []byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json","config":{"mediaType":"application/vnd.oci.image.config.v1+json","digest":"sha256:44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a","size":2},"layers":[],"annotations":{"v":"2"}}`),
+		}
+		current = "v1"
+		blob    = []byte("{}")
+		gets    = map[string]int{}
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Docker-Distribution-API-Version", "registry/2.0")
+		switch r.URL.Path {
+		case "/v2/":
+		case "/v2/test/manifests/latest":
+			payload := manifests[current]
+			d := digest.FromBytes(payload).String()
+			if r.Header.Get("If-None-Match") == `"`+d+`"` {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			w.Header().Set("Docker-Content-Digest", d)
+			w.Header().Set("Content-Type", ociv1.MediaTypeImageManifest)
+			if r.Method == "GET" {
+				gets["manifest"]++
+				w.Write(payload)
+			}
+		case "/v2/test/blobs/" + digest.FromBytes(blob).String():
+			gets["blob"]++
+			w.Write(blob)
+		case "/v2/test/blobs/" + digest.FromString("other").String():
+			gets["corrupt"]++
+			w.Write(blob)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	ctx := context.Background()
+	dir := t.TempDir()
+	r, err := New(ctx, types.AuthConfig{ServerAddress: ts.URL}, Opt{CacheDir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, desc, err := r.Manifest(ctx, "test", "latest"); err != nil {
+			t.Fatal(err)
+		} else if desc.Digest != digest.FromBytes(manifests["v1"]) {
+			t.Fatalf("expected digest of v1, got %s", desc.Digest)
+		}
+	}
+	if gets["manifest"] != 1 {
+		t.Fatalf("expected the manifest to be fetched once, got %d", gets["manifest"])
+	}
+
+	// The tag moved, so the new manifest has to be fetched.
+	current = "v2"
+	if _, desc, err := r.Manifest(ctx, "test", "latest"); err != nil {
+		t.Fatal(err)
+	} else if desc.Digest != digest.FromBytes(manifests["v2"]) {
+		t.Fatalf("expected digest of v2, got %s", desc.Digest)
+	}
+	if gets["manifest"] != 2 {
+		t.Fatalf("expected the moved tag to be refetched, got %d fetches", gets["manifest"])
+	}
+
+	// The old manifest is still cached by digest.
+	if _, _, err := r.Manifest(ctx, "test", digest.FromBytes(manifests["v1"]).String()); err != nil {
+		t.Fatal(err)
+	}
+	if gets["manifest"] != 2 {
+		t.Fatalf("expected the manifest to be served from the cache, got %d fetches", gets["manifest"])
+	}
+
+	for i := 0; i < 2; i++ {
+		var config map[string]interface{}
+		if err := r.GetConfig(ctx, "test", digest.FromBytes(blob), &config); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if gets["blob"] != 1 {
+		t.Fatalf("expected the blob to be fetched once, got %d", gets["blob"])
+	}
+
+	// Content that does not match its digest is never cached.
+	for i := 0; i < 2; i++ {
+		rc, err := r.DownloadLayer(ctx, "test", digest.FromString("other"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.ReadAll(rc); err == nil {
+			t.Fatal("expected a digest mismatch")
+		}
+		rc.Close()
+	}
+	if gets["corrupt"] != 2 {
+		t.Fatalf("expected the corrupt blob to be fetched twice, got %d", gets["corrupt"])
+	}
+	if _, err := os.Stat(filepath.Join(dir, "blobs", "sha256", digest.FromString("other").Encoded())); !os.IsNotExist(err) {
+		t.Fatalf("expected the corrupt blob not to be cached, got %v", err)
+	}
+}
+
+func TestCachePrune(t *testing.T) {
+	c, err := NewCache(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	old := []byte("old blob")
+	recent := []byte("recent blob")
+	for _, b := range [][]byte{old, recent} {
+		rc := c.CacheBlob(io.NopCloser(bytes.NewReader(b)), digest.FromBytes(b))
+		if _, err := io.ReadAll(rc); err != nil {
+			t.Fatal(err)
+		}
+		rc.Close()
+	}
+	oldTime := time.Now().Add(-48 * time.Hour)
+	if err := os.Chtimes(c.path("blobs", digest.FromBytes(old)), oldTime, oldTime); err != nil {
+		t.Fatal(err)
+	}
+
+	removed, freed, err := c.Prune(0, 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 1 || freed != int64(len(old)) {
+		t.Fatalf("expected the old blob to be removed, got %d entries and %d bytes", removed, freed)
+	}
+	if _, ok := c.Blob(digest.FromBytes(old)); ok {
+		t.Fatal("expected the old blob to be gone")
+	}
+
+	removed, _, err = c.Prune(1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 1 {
+		t.Fatalf("expected the cache to be emptied to fit the size, removed %d", removed)
+	}
+}
+
+func TestCacheTagRepositories(t *testing.T) {
+	c, err := NewCache(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	foo := digest.FromString("foo:bar")
+	fooBar := digest.FromString("foo/bar:latest")
+	if err := c.PutTag("example.com", "foo", "bar", foo); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.PutTag("example.com", "foo/bar", "latest", fooBar); err != nil {
+		t.Fatalf("caching a tag of foo/bar next to the tag bar of foo failed: %v", err)
+	}
+
+	if d, ok := c.Tag("example.com", "foo", "bar"); !ok || d != foo {
+		t.Fatalf("expected foo:bar to point at %s, got %s (%t)", foo, d, ok)
+	}
+	if d, ok := c.Tag("example.com", "foo/bar", "latest"); !ok || d != fooBar {
+		t.Fatalf("expected foo/bar:latest to point at %s, got %s (%t)", fooBar, d, ok)
+	}
+}
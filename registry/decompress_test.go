@@ -0,0 +1,56 @@
+package registry
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestDecompress(t *testing.T) {
+	content := []byte("layer content")
+
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write(content)
+	zw.Close()
+
+	var zst bytes.Buffer
+	enc, err := zstd.NewWriter(&zst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc.Write(content)
+	enc.Close()
+
+	testcases := map[string][]byte{
+		"gzip":         gz.Bytes(),
+		"zstd":         zst.Bytes(),
+		"uncompressed": content,
+	}
+	for name, compressed := range testcases {
+		rc, err := Decompress(bytes.NewReader(compressed))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		b, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !bytes.Equal(b, content) {
+			t.Fatalf("%s: expected %q, got %q", name, content, b)
+		}
+	}
+
+	// Content shorter than the magic numbers is returned as it is.
+	rc, err := Decompress(bytes.NewReader([]byte{0x1f}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := io.ReadAll(rc); !bytes.Equal(b, []byte{0x1f}) {
+		t.Fatalf("expected the short content unchanged, got %q", b)
+	}
+}
@@ -2,35 +2,99 @@ package registry
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"sort"
+	"strings"
+	"sync"
 
-	"github.com/distribution/distribution/v3/manifest/schema2"
 	digest "github.com/opencontainers/go-digest"
 )
 
-// Delete removes a repository digest from the registry.
+// Delete removes a repository digest from the registry. Every tag pointing at
+// the digest is removed with it, see DeleteTag to only remove a single tag.
 // https://docs.docker.com/registry/spec/api/#deleting-an-image
 func (r *Registry) Delete(ctx context.Context, repository string, digest digest.Digest) (err error) {
-	url := r.url("/v2/%s/manifests/%s", repository, digest)
-	r.Logf("registry.manifests.delete url=%s repository=%s digest=%s",
-		url, repository, digest)
+	return r.deleteManifest(ctx, repository, digest.String())
+}
+
+// DeleteTag removes a tag from a repository, leaving the manifest and any
+// other tags pointing at it in place. Registries that do not support deleting
+// tags return an error matching ErrUnsupported.
+// https://github.com/opencontainers/distribution-spec/blob/v1.1.0/spec.md#deleting-tags
+func (r *Registry) DeleteTag(ctx context.Context, repository, tag string) error {
+	err := r.deleteManifest(ctx, repository, tag)
+	if errors.Is(err, ErrBadRequest) || errors.Is(err, ErrUnsupported) {
+		// Older registries only accept a digest as reference, others have
+		// deleting tags disabled and answer 405, or 404 with UNSUPPORTED.
+		return fmt.Errorf("%w: deleting tag %s:%s: %w", ErrUnsupported, repository, tag, err)
+	}
+	return err
+}
 
+func (r *Registry) deleteManifest(ctx context.Context, repository, ref string) error {
+	url := r.url("/v2/%s/manifests/%s", repository, ref)
+	r.Logf("registry.manifests.delete url=%s repository=%s ref=%s",
+		url, repository, ref)
+
+	ctx = WithScopes(ctx, RepositoryScope(repository, "delete"))
 	req, err := http.NewRequest("DELETE", url, nil)
 	if err != nil {
 		return err
 	}
 
-	req.Header.Add("Accept", fmt.Sprintf("%s;q=0.9", schema2.MediaTypeManifest))
+	req.Header.Add("Accept", strings.Join(ManifestSupportedSchemeTypes, ","))
 	resp, err := r.Client.Do(req.WithContext(ctx))
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode == http.StatusAccepted || resp.StatusCode == http.StatusNotFound {
+	if resp.StatusCode == http.StatusAccepted {
+		return nil
+	}
+
+	err = newError(resp)
+	if resp.StatusCode == http.StatusNotFound && !errors.Is(err, ErrUnsupported) {
+		// The reference is already gone.
 		return nil
 	}
+	return err
+}
+
+// TagsForDigest returns the tags of a repository that point at a digest,
+// sorted by name.
+func (r *Registry) TagsForDigest(ctx context.Context, repository string, d digest.Digest) ([]string, error) {
+	tags, err := r.Tags(ctx, repository)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		mu      sync.Mutex
+		matches []string
+	)
+	e := NewExecutor(r.Opt.Parallelism)
+	for _, tag := range tags {
+		tag := tag
+		e.Go(ctx, tag, func(ctx context.Context) error {
+			desc, err := r.HeadManifest(ctx, repository, tag)
+			if err != nil {
+				return err
+			}
+			if desc.Digest == d {
+				mu.Lock()
+				matches = append(matches, tag)
+				mu.Unlock()
+			}
+			return nil
+		})
+	}
+	if err := e.Wait(); err != nil {
+		return nil, err
+	}
 
-	return fmt.Errorf("got status code: %d", resp.StatusCode)
+	sort.Strings(matches)
+	return matches, nil
 }
@@ -0,0 +1,114 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	digest "github.com/opencontainers/go-digest"
+	ociv1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestDeleteTag(t *testing.T) {
+	var (
+		deleted []string
+		accept  string
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Docker-Distribution-API-Version", "registry/2.0")
+		switch {
+		case r.Method == "DELETE" && r.URL.Path == "/v2/repo/manifests/old":
+			deleted = append(deleted, "old")
+			accept = r.Header.Get("Accept")
+			w.WriteHeader(http.StatusAccepted)
+		case r.Method == "DELETE" && r.URL.Path == "/v2/repo/manifests/disabled":
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		case r.Method == "DELETE" && r.URL.Path == "/v2/repo/manifests/unsupported":
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"errors":[{"code":"UNSUPPORTED","message":"the operation is unsupported"}]}`))
+		case r.Method == "DELETE" && r.URL.Path == "/v2/repo/manifests/gone":
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"errors":[{"code":"MANIFEST_UNKNOWN","message":"manifest unknown"}]}`))
+		case r.Method == "DELETE":
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"errors":[{"code":"DIGEST_INVALID","message":"provided digest did not match uploaded content"}]}`))
+		default:
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	defer ts.Close()
+
+	ctx := context.Background()
+	r, err := New(ctx, types.AuthConfig{ServerAddress: ts.URL}, Opt{})
+	if err != nil {
+		t.Fatalf("expected no error creating client, got %v", err)
+	}
+
+	if err := r.DeleteTag(ctx, "repo", "old"); err != nil {
+		t.Fatalf("DeleteTag: %v", err)
+	}
+	if !reflect.DeepEqual(deleted, []string{"old"}) {
+		t.Errorf("expected old to be deleted, got %v", deleted)
+	}
+	for _, mediaType := range []string{ociv1.MediaTypeImageManifest, ociv1.MediaTypeImageIndex} {
+		if !strings.Contains(accept, mediaType) {
+			t.Errorf("expected Accept to contain %s, got %q", mediaType, accept)
+		}
+	}
+
+	if err := r.DeleteTag(ctx, "repo", "other"); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("expected ErrUnsupported from a registry that only deletes digests, got %v", err)
+	}
+	for _, tag := range []string{"disabled", "unsupported"} {
+		if err := r.DeleteTag(ctx, "repo", tag); !errors.Is(err, ErrUnsupported) {
+			t.Errorf("%s: expected ErrUnsupported from a registry with tag deletion disabled, got %v", tag, err)
+		}
+	}
+	if err := r.DeleteTag(ctx, "repo", "gone"); err != nil {
+		t.Errorf("expected no error deleting a tag that does not exist, got %v", err)
+	}
+}
+
+func TestTagsForDigest(t *testing.T) {
+	d := digest.FromString("manifest")
+	tags := map[string]digest.Digest{
+		"latest": d,
+		"v1":     d,
+		"v0":     digest.FromString("other"),
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Docker-Distribution-API-Version", "registry/2.0")
+		switch {
+		case r.URL.Path == "/v2/repo/tags/list":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"name":"repo","tags":["latest","v0","v1"]}`))
+		case r.Method == "HEAD" && strings.HasPrefix(r.URL.Path, "/v2/repo/manifests/"):
+			w.Header().Set("Content-Type", ociv1.MediaTypeImageManifest)
+			w.Header().Set("Docker-Content-Digest", tags[strings.TrimPrefix(r.URL.Path, "/v2/repo/manifests/")].String())
+			w.WriteHeader(http.StatusOK)
+		default:
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	defer ts.Close()
+
+	ctx := context.Background()
+	r, err := New(ctx, types.AuthConfig{ServerAddress: ts.URL}, Opt{})
+	if err != nil {
+		t.Fatalf("expected no error creating client, got %v", err)
+	}
+
+	got, err := r.TagsForDigest(ctx, "repo", d)
+	if err != nil {
+		t.Fatalf("TagsForDigest: %v", err)
+	}
+	if expected := []string{"latest", "v1"}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+}
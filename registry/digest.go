@@ -2,7 +2,6 @@ package registry
 
 import (
 	"context"
-	"fmt"
 	"net/http"
 
 	"github.com/distribution/distribution/v3/manifest/schema2"
@@ -32,8 +31,8 @@ func (r *Registry) Digest(ctx context.Context, image Image) (digest.Digest, erro
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
-		return "", fmt.Errorf("got status code: %d", resp.StatusCode)
+	if resp.StatusCode != http.StatusOK {
+		return "", newError(resp)
 	}
 
 	return digest.Parse(resp.Header.Get("Docker-Content-Digest"))
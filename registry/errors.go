@@ -0,0 +1,137 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// ErrorCode is an error code of the distribution API.
+// https://github.com/opencontainers/distribution-spec/blob/v1.1.0/spec.md#error-codes
+type ErrorCode string
+
+// The error codes defined by the distribution API.
+const (
+	ErrorCodeBlobUnknown         ErrorCode = "BLOB_UNKNOWN"
+	ErrorCodeBlobUploadInvalid   ErrorCode = "BLOB_UPLOAD_INVALID"
+	ErrorCodeBlobUploadUnknown   ErrorCode = "BLOB_UPLOAD_UNKNOWN"
+	ErrorCodeDigestInvalid       ErrorCode = "DIGEST_INVALID"
+	ErrorCodeManifestBlobUnknown ErrorCode = "MANIFEST_BLOB_UNKNOWN"
+	ErrorCodeManifestInvalid     ErrorCode = "MANIFEST_INVALID"
+	ErrorCodeManifestUnknown     ErrorCode = "MANIFEST_UNKNOWN"
+	ErrorCodeNameInvalid         ErrorCode = "NAME_INVALID"
+	ErrorCodeNameUnknown         ErrorCode = "NAME_UNKNOWN"
+	ErrorCodeSizeInvalid         ErrorCode = "SIZE_INVALID"
+	ErrorCodeUnauthorized        ErrorCode = "UNAUTHORIZED"
+	ErrorCodeDenied              ErrorCode = "DENIED"
+	ErrorCodeUnsupported         ErrorCode = "UNSUPPORTED"
+	ErrorCodeTooManyRequests     ErrorCode = "TOOMANYREQUESTS"
+)
+
+// maxErrorBody limits how much of an error response is read.
+const maxErrorBody = 64 << 10
+
+// ErrorDetail is a single entry of the errors envelope of a registry response.
+type ErrorDetail struct {
+	Code    ErrorCode       `json:"code"`
+	Message string          `json:"message,omitempty"`
+	Detail  json.RawMessage `json:"detail,omitempty"`
+}
+
+func (d ErrorDetail) String() string {
+	s := string(d.Code)
+	if d.Message != "" {
+		s += ": " + d.Message
+	}
+	if len(d.Detail) > 0 && string(d.Detail) != "null" {
+		s += " " + string(d.Detail)
+	}
+	return s
+}
+
+// Error is returned for a registry response with an unexpected status code.
+// The errors envelope of the response body is parsed into Errors, if the body
+// is not an envelope it is kept in Body instead.
+//
+// Use errors.As to inspect it, errors.Is matches it against ErrBadRequest,
+// ErrUnauthorized, ErrForbidden, ErrResourceNotFound, ErrTooManyRequests,
+// ErrUnsupported and ErrUnexpectedHttpStatusCode.
+type Error struct {
+	StatusCode int
+	Errors     []ErrorDetail
+	Body       []byte
+}
+
+// newError reads the body of resp into an Error.
+func newError(resp *http.Response) *Error {
+	e := &Error{StatusCode: resp.StatusCode}
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	var envelope struct {
+		Errors []ErrorDetail `json:"errors"`
+	}
+	if err := json.Unmarshal(body, &envelope); err == nil && len(envelope.Errors) > 0 {
+		e.Errors = envelope.Errors
+	} else {
+		e.Body = body
+	}
+	return e
+}
+
+func (e *Error) Error() string {
+	status := fmt.Sprintf("%d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	if len(e.Errors) == 0 {
+		body := strings.TrimSpace(string(e.Body))
+		if body == "" {
+			return "registry returned " + status
+		}
+		return fmt.Sprintf("registry returned %s: %s", status, body)
+	}
+
+	details := make([]string, 0, len(e.Errors))
+	for _, d := range e.Errors {
+		details = append(details, d.String())
+	}
+	return fmt.Sprintf("registry returned %s: %s", status, strings.Join(details, "; "))
+}
+
+// HasCode reports whether the registry returned the error code.
+func (e *Error) HasCode(code ErrorCode) bool {
+	for _, d := range e.Errors {
+		if d.Code == code {
+			return true
+		}
+	}
+	return false
+}
+
+// Is maps the status code and error codes to the error sentinels of the
+// package.
+func (e *Error) Is(target error) bool {
+	switch target {
+	case ErrBadRequest:
+		return e.StatusCode == http.StatusBadRequest
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized || e.HasCode(ErrorCodeUnauthorized)
+	case ErrForbidden:
+		return e.StatusCode == http.StatusForbidden || e.HasCode(ErrorCodeDenied)
+	case ErrResourceNotFound:
+		return e.StatusCode == http.StatusNotFound ||
+			e.HasCode(ErrorCodeManifestUnknown) || e.HasCode(ErrorCodeBlobUnknown) || e.HasCode(ErrorCodeNameUnknown)
+	case ErrTooManyRequests:
+		return e.StatusCode == http.StatusTooManyRequests || e.HasCode(ErrorCodeTooManyRequests)
+	case ErrUnsupported:
+		return e.StatusCode == http.StatusMethodNotAllowed || e.HasCode(ErrorCodeUnsupported)
+	case ErrUploadUnknown:
+		return e.HasCode(ErrorCodeBlobUploadUnknown)
+	case ErrUnexpectedHttpStatusCode:
+		switch e.StatusCode {
+		case http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound:
+			return false
+		}
+		return true
+	}
+	return false
+}
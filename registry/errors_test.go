@@ -0,0 +1,85 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+)
+
+func TestError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Docker-Distribution-API-Version", "registry/2.0")
+		switch r.URL.Path {
+		case "/v2/":
+		case "/v2/missing/manifests/latest":
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"errors":[{"code":"MANIFEST_UNKNOWN","message":"manifest unknown","detail":{"Tag":"latest"}}]}`))
+		case "/v2/private/tags/list":
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"errors":[{"code":"DENIED","message":"requested access to the resource is denied"}]}`))
+		case "/v2/limited/tags/list":
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte(`{"errors":[{"code":"TOOMANYREQUESTS","message":"You have reached your pull rate limit."}]}`))
+		case "/v2/broken/tags/list":
+			w.WriteHeader(http.StatusBadGateway)
+			w.Write([]byte(`<html>bad gateway</html>`))
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			w.Write([]byte(`{"errors":[{"code":"UNSUPPORTED","message":"The operation is unsupported."}]}`))
+		}
+	}))
+	defer ts.Close()
+
+	ctx := context.Background()
+	r, err := New(ctx, types.AuthConfig{ServerAddress: ts.URL}, Opt{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, err = r.Manifest(ctx, "missing", "latest")
+	var regErr *Error
+	if !errors.As(err, &regErr) {
+		t.Fatalf("expected an *Error, got %T: %v", err, err)
+	}
+	if regErr.StatusCode != http.StatusNotFound || !regErr.HasCode(ErrorCodeManifestUnknown) {
+		t.Fatalf("unexpected error %+v", regErr)
+	}
+	if string(regErr.Errors[0].Detail) != `{"Tag":"latest"}` {
+		t.Fatalf("expected the detail to be kept, got %s", regErr.Errors[0].Detail)
+	}
+	if !errors.Is(err, ErrResourceNotFound) || errors.Is(err, ErrUnexpectedHttpStatusCode) {
+		t.Fatalf("expected only ErrResourceNotFound to match %v", err)
+	}
+
+	for _, tc := range []struct {
+		repo   string
+		target error
+		code   ErrorCode
+		msg    string
+	}{
+		{"private", ErrForbidden, ErrorCodeDenied, "403 Forbidden: DENIED: requested access"},
+		{"limited", ErrTooManyRequests, ErrorCodeTooManyRequests, "429 Too Many Requests: TOOMANYREQUESTS"},
+		{"broken", ErrUnexpectedHttpStatusCode, "", "502 Bad Gateway: <html>bad gateway</html>"},
+		{"other", ErrUnsupported, ErrorCodeUnsupported, "405 Method Not Allowed: UNSUPPORTED"},
+	} {
+		_, err := r.Tags(ctx, tc.repo)
+		if !errors.Is(err, tc.target) {
+			t.Errorf("%s: expected %v, got %v", tc.repo, tc.target, err)
+		}
+		if !errors.As(err, &regErr) {
+			t.Errorf("%s: expected an *Error, got %T", tc.repo, err)
+			continue
+		}
+		if tc.code != "" && !regErr.HasCode(tc.code) {
+			t.Errorf("%s: expected code %s, got %v", tc.repo, tc.code, regErr.Errors)
+		}
+		if !strings.Contains(err.Error(), tc.msg) {
+			t.Errorf("%s: expected %q in %q", tc.repo, tc.msg, err)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// DefaultParallelism is the number of concurrent operations an Executor runs
+// when Opt.Parallelism is not set.
+const DefaultParallelism = 8
+
+// ItemError is the error of a single item of a bulk operation.
+type ItemError struct {
+	Item string
+	Err  error
+}
+
+func (e *ItemError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Item, e.Err)
+}
+
+func (e *ItemError) Unwrap() error {
+	return e.Err
+}
+
+// Errors holds the errors of the items of a bulk operation that failed.
+type Errors []*ItemError
+
+func (e Errors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, err := range e {
+		msgs = append(msgs, err.Error())
+	}
+	return fmt.Sprintf("%d operations failed: %s", len(e), strings.Join(msgs, "; "))
+}
+
+// Executor runs the items of a bulk operation, such as fetching the tags of
+// every repository in a catalog, with bounded concurrency. The error of
+// every item is collected instead of aborting the operation.
+type Executor struct {
+	sem  chan struct{}
+	wg   sync.WaitGroup
+	mu   sync.Mutex
+	errs Errors
+}
+
+// NewExecutor returns an Executor that runs at most parallelism items at the
+// same time. A parallelism of zero or less means DefaultParallelism.
+func NewExecutor(parallelism int) *Executor {
+	if parallelism <= 0 {
+		parallelism = DefaultParallelism
+	}
+	return &Executor{sem: make(chan struct{}, parallelism)}
+}
+
+// Go runs fn for item in a new goroutine. It blocks until fewer than the
+// maximum number of items are running. If ctx is done before that, fn is not
+// run and the context error is recorded for item.
+func (e *Executor) Go(ctx context.Context, item string, fn func(ctx context.Context) error) {
+	select {
+	case e.sem <- struct{}{}:
+	case <-ctx.Done():
+		e.fail(item, ctx.Err())
+		return
+	}
+
+	e.wg.Add(1)
+	go func() {
+		defer func() {
+			<-e.sem
+			e.wg.Done()
+		}()
+		if err := fn(ctx); err != nil {
+			e.fail(item, err)
+		}
+	}()
+}
+
+// Wait waits for all items to finish. It returns the errors of the items that
+// failed as Errors, or nil if all of them succeeded.
+func (e *Executor) Wait() error {
+	e.wg.Wait()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if len(e.errs) == 0 {
+		return nil
+	}
+	return e.errs
+}
+
+func (e *Executor) fail(item string, err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.errs = append(e.errs, &ItemError{Item: item, Err: err})
+}
@@ -0,0 +1,88 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestExecutor(t *testing.T) {
+	var (
+		mu            sync.Mutex
+		running, peak int
+		errOdd        = errors.New("odd")
+		ex            = NewExecutor(3)
+		ctx           = context.Background()
+	)
+
+	for i := 0; i < 20; i++ {
+		i := i
+		ex.Go(ctx, fmt.Sprint(i), func(ctx context.Context) error {
+			mu.Lock()
+			running++
+			if running > peak {
+				peak = running
+			}
+			mu.Unlock()
+
+			time.Sleep(time.Millisecond)
+
+			mu.Lock()
+			running--
+			mu.Unlock()
+
+			if i%2 == 1 {
+				return errOdd
+			}
+			return nil
+		})
+	}
+
+	err := ex.Wait()
+	if peak > 3 {
+		t.Fatalf("expected at most 3 concurrent items, got %d", peak)
+	}
+
+	var errs Errors
+	if !errors.As(err, &errs) {
+		t.Fatalf("expected Errors, got %T: %v", err, err)
+	}
+	if len(errs) != 10 {
+		t.Fatalf("expected 10 failed items, got %d", len(errs))
+	}
+	for _, e := range errs {
+		if !errors.Is(e, errOdd) {
+			t.Fatalf("expected item %s to fail with %v, got %v", e.Item, errOdd, e.Err)
+		}
+	}
+}
+
+func TestExecutorCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	ex := NewExecutor(1)
+
+	release := make(chan struct{})
+	ex.Go(ctx, "first", func(ctx context.Context) error {
+		<-release
+		return nil
+	})
+	cancel()
+	// There is no free slot, so the second item is not run.
+	ex.Go(ctx, "second", func(ctx context.Context) error {
+		t.Error("expected second item not to run")
+		return nil
+	})
+	close(release)
+
+	errs, ok := ex.Wait().(Errors)
+	if !ok || len(errs) != 1 || errs[0].Item != "second" || !errors.Is(errs[0], context.Canceled) {
+		t.Fatalf("expected the second item to be canceled, got %v", errs)
+	}
+
+	if err := NewExecutor(0).Wait(); err != nil {
+		t.Fatalf("expected no error without items, got %v", err)
+	}
+}
@@ -0,0 +1,367 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+)
+
+// DefaultFetchChunkSize is the size of the byte ranges FetchBlob downloads
+// in parallel when Opt.FetchChunkSize is not set.
+const DefaultFetchChunkSize int64 = 16 << 20
+
+// errRangeEnd is returned by rangeFetcher.fetch when the registry reports that
+// the requested range starts at the end of the blob.
+var errRangeEnd = errors.New("range starts at the end of the blob")
+
+// maxFetchResumes is the number of times in a row a byte range is resumed
+// without receiving any data before FetchBlob gives up.
+const maxFetchResumes = 5
+
+// FetchBlob downloads the blob described by desc for a repository into f.
+//
+// If the registry, or the storage it redirects to, supports Range requests
+// the blob is downloaded in byte ranges of Opt.FetchChunkSize, up to
+// Opt.Parallelism at a time. A range whose connection drops is resumed from
+// the last byte written. Opt.Timeout applies to each stalled read rather than
+// to the whole download, so large blobs are not cut off. Registries without
+// range support are read in a single stream, which cannot be resumed: if its
+// connection drops FetchBlob fails, and calling it again starts over from the
+// first byte.
+//
+// Once complete, the content of f is verified against the digest and size of
+// desc. A negative size is taken from the response instead. If a cache is
+// configured, blobs are served from it and added to it once verified.
+func (r *Registry) FetchBlob(ctx context.Context, repository string, desc distribution.Descriptor, f *os.File) error {
+	if err := desc.Digest.Validate(); err != nil {
+		return err
+	}
+
+	if r.cache != nil {
+		if cached, ok := r.cache.Blob(desc.Digest); ok {
+			r.Logf("registry.blob.fetch.cached repository=%s digest=%s", repository, desc.Digest)
+			defer cached.Close()
+			n, err := copyAt(f, 0, cached)
+			if err != nil {
+				return err
+			}
+			return f.Truncate(n)
+		}
+	}
+
+	url := r.url("/v2/%s/blobs/%s", repository, desc.Digest)
+	chunkSize := r.Opt.FetchChunkSize
+	if chunkSize <= 0 {
+		chunkSize = DefaultFetchChunkSize
+	}
+	r.Logf("registry.blob.fetch url=%s repository=%s digest=%s size=%d", url, repository, desc.Digest, desc.Size)
+
+	// The first range tells whether the registry supports ranges and how
+	// large the blob is.
+	fetch := &rangeFetcher{r: r, url: url, f: f}
+	size, supported, n, err := fetch.probe(ctx, chunkSize-1)
+	if err != nil {
+		return fmt.Errorf("downloading blob %s failed: %w", desc.Digest, err)
+	}
+	if desc.Size >= 0 && size >= 0 && size != desc.Size {
+		return fmt.Errorf("%w: registry reports %d bytes for %s, expected %d", ErrSizeMismatch, size, desc.Digest, desc.Size)
+	}
+	if size < 0 {
+		size = desc.Size
+	}
+
+	if supported {
+		end := chunkSize - 1
+		if size >= 0 && end >= size-1 {
+			end = size - 1
+		}
+		if size < 0 {
+			// Without a known size the rest is fetched as a single open range.
+			end = -1
+		}
+
+		// Finish the first range, then fetch the others in parallel.
+		if err := fetch.resume(ctx, n, end); err != nil {
+			return fmt.Errorf("downloading blob %s failed: %w", desc.Digest, err)
+		}
+		if size >= 0 {
+			e := NewExecutor(r.Opt.Parallelism)
+			for start := chunkSize; start < size; start += chunkSize {
+				start, end := start, min(start+chunkSize, size)-1
+				e.Go(ctx, fmt.Sprintf("bytes=%d-%d", start, end), func(ctx context.Context) error {
+					return fetch.resume(ctx, start, end)
+				})
+			}
+			if err := e.Wait(); err != nil {
+				return fmt.Errorf("downloading blob %s failed: %w", desc.Digest, err)
+			}
+		}
+	}
+
+	if size >= 0 {
+		if err := f.Truncate(size); err != nil {
+			return err
+		}
+	} else if size, err = f.Seek(0, io.SeekEnd); err != nil {
+		return err
+	}
+
+	// Verify the reassembled blob, adding it to the cache on the way.
+	var verified io.ReadCloser
+	verified, err = VerifyReader(io.NopCloser(io.NewSectionReader(f, 0, size)), desc.Digest, desc.Size)
+	if err != nil {
+		return err
+	}
+	if r.cache != nil {
+		verified = r.cache.CacheBlob(verified, desc.Digest)
+	}
+	defer verified.Close()
+	if _, err := io.Copy(io.Discard, verified); err != nil {
+		return err
+	}
+	return nil
+}
+
+// FetchBlobReader downloads the blob described by desc with FetchBlob into a
+// temporary file and returns it positioned at the start. The file is removed
+// when the reader is closed.
+func (r *Registry) FetchBlobReader(ctx context.Context, repository string, desc distribution.Descriptor) (io.ReadCloser, error) {
+	f, err := os.CreateTemp("", "reg-blob-")
+	if err != nil {
+		return nil, err
+	}
+	tmp := &tempFile{f}
+
+	if err := r.FetchBlob(ctx, repository, desc, f); err != nil {
+		tmp.Close()
+		return nil, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		tmp.Close()
+		return nil, err
+	}
+	return tmp, nil
+}
+
+// tempFile is a file that is removed when it is closed.
+type tempFile struct {
+	*os.File
+}
+
+func (t *tempFile) Close() error {
+	err := t.File.Close()
+	os.Remove(t.Name())
+	return err
+}
+
+// rangeFetcher downloads byte ranges of a blob into a file.
+type rangeFetcher struct {
+	r   *Registry
+	url string
+	f   *os.File
+}
+
+// probe fetches the range from the start of the blob to end. It returns the
+// size of the blob, or -1 if it is unknown, whether the registry served a
+// range and the number of bytes written. If the registry does not support
+// ranges the whole blob is read, and an error is returned if that fails.
+func (rf *rangeFetcher) probe(ctx context.Context, end int64) (int64, bool, int64, error) {
+	resp, cancel, err := rf.get(ctx, fmt.Sprintf("bytes=0-%d", end))
+	if err != nil {
+		return -1, false, 0, err
+	}
+	defer cancel()
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		start, last, size, err := parseContentRange(resp.Header.Get("Content-Range"))
+		if err != nil {
+			return -1, false, 0, err
+		}
+		if start != 0 {
+			return -1, false, 0, fmt.Errorf("registry returned range starting at %d, expected 0", start)
+		}
+		if size < 0 && last < end {
+			// A short range without a size is the whole blob.
+			size = last + 1
+		}
+		// A dropped connection is resumed by the caller.
+		n, _ := copyAt(rf.f, 0, resp.Body)
+		return size, true, n, nil
+	case http.StatusOK:
+		rf.r.Logf("registry.blob.fetch url=%s ranges=unsupported", rf.url)
+		n, err := copyAt(rf.f, 0, resp.Body)
+		if err == nil && resp.ContentLength >= 0 && n != resp.ContentLength {
+			err = io.ErrUnexpectedEOF
+		}
+		return resp.ContentLength, false, n, err
+	}
+	return -1, false, 0, newError(resp)
+}
+
+// resume fetches the range from start to end, inclusive, resuming from the
+// last byte written when the connection drops. An end of -1 fetches to the
+// end of the blob.
+func (rf *rangeFetcher) resume(ctx context.Context, start, end int64) error {
+	var failures int
+	for end < 0 || start <= end {
+		rng := fmt.Sprintf("bytes=%d-", start)
+		if end >= 0 {
+			rng += strconv.FormatInt(end, 10)
+		}
+
+		n, err := rf.fetch(ctx, start, rng)
+		start += n
+		if errors.Is(err, errRangeEnd) {
+			if end < 0 {
+				// The blob of unknown size ended exactly where the last
+				// range did.
+				return nil
+			}
+			return fmt.Errorf("%w: blob ended at %d, expected %d bytes", ErrSizeMismatch, start, end+1)
+		}
+		if err == nil {
+			if end < 0 || start > end {
+				return nil
+			}
+			err = io.ErrUnexpectedEOF
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		var rerr *Error
+		if errors.As(err, &rerr) {
+			// The registry refused the range, resuming will not help.
+			return err
+		}
+
+		if n > 0 {
+			failures = 0
+		} else if failures++; failures >= maxFetchResumes {
+			return err
+		}
+		rf.r.Logf("registry.blob.fetch.resume url=%s offset=%d err=%v", rf.url, start, err)
+	}
+	return nil
+}
+
+// fetch requests a single range and writes it into the file at start.
+func (rf *rangeFetcher) fetch(ctx context.Context, start int64, rng string) (int64, error) {
+	resp, cancel, err := rf.get(ctx, rng)
+	if err != nil {
+		return 0, err
+	}
+	defer cancel()
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+		// The registry sends the size of the blob as bytes */size, if at all.
+		size, err := strconv.ParseInt(strings.TrimPrefix(resp.Header.Get("Content-Range"), "bytes */"), 10, 64)
+		if err != nil || size == start {
+			return 0, errRangeEnd
+		}
+	}
+	if resp.StatusCode != http.StatusPartialContent {
+		return 0, newError(resp)
+	}
+	got, _, _, err := parseContentRange(resp.Header.Get("Content-Range"))
+	if err != nil {
+		return 0, err
+	}
+	if got != start {
+		return 0, fmt.Errorf("registry returned range starting at %d, expected %d", got, start)
+	}
+	return copyAt(rf.f, start, resp.Body)
+}
+
+// get sends a range request. Instead of limiting the whole request, the
+// timeout of the registry cancels it once no data arrived for that long.
+func (rf *rangeFetcher) get(ctx context.Context, rng string) (*http.Response, func(), error) {
+	req, err := http.NewRequest("GET", rf.url, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Range", rng)
+
+	ctx, cancel := context.WithCancel(ctx)
+	var timer *time.Timer
+	if rf.r.Opt.Timeout > 0 {
+		timer = time.AfterFunc(rf.r.Opt.Timeout, cancel)
+	}
+	stop := func() {
+		if timer != nil {
+			timer.Stop()
+		}
+		cancel()
+	}
+
+	client := &http.Client{Transport: rf.r.Client.Transport}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		stop()
+		return nil, nil, err
+	}
+	resp.Body = &idleTimeoutReader{ReadCloser: resp.Body, timer: timer, timeout: rf.r.Opt.Timeout}
+	return resp, stop, nil
+}
+
+// idleTimeoutReader pushes back the timeout of a request whenever data
+// arrives.
+type idleTimeoutReader struct {
+	io.ReadCloser
+	timer   *time.Timer
+	timeout time.Duration
+}
+
+func (r *idleTimeoutReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 && r.timer != nil {
+		r.timer.Reset(r.timeout)
+	}
+	return n, err
+}
+
+// copyAt copies src into f at offset and returns the number of bytes written.
+func copyAt(f *os.File, offset int64, src io.Reader) (int64, error) {
+	return io.Copy(io.NewOffsetWriter(f, offset), src)
+}
+
+// parseContentRange parses a Content-Range header in the form
+// bytes start-end/size. The size is -1 if the registry did not send it.
+func parseContentRange(v string) (int64, int64, int64, error) {
+	invalid := fmt.Errorf("invalid Content-Range %q", v)
+
+	rng, size, ok := strings.Cut(strings.TrimPrefix(v, "bytes "), "/")
+	if !ok || !strings.HasPrefix(v, "bytes ") {
+		return 0, 0, 0, invalid
+	}
+	first, last, ok := strings.Cut(rng, "-")
+	if !ok {
+		return 0, 0, 0, invalid
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil {
+		return 0, 0, 0, invalid
+	}
+	end, err := strconv.ParseInt(last, 10, 64)
+	if err != nil || end < start {
+		return 0, 0, 0, invalid
+	}
+
+	total := int64(-1)
+	if size != "*" {
+		if total, err = strconv.ParseInt(size, 10, 64); err != nil {
+			return 0, 0, 0, invalid
+		}
+	}
+	return start, end, total, nil
+}
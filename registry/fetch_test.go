@@ -0,0 +1,243 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/docker/docker/api/types"
+	digest "github.com/opencontainers/go-digest"
+)
+
+// blobServer serves blob at /v2/repo/blobs/<digest>. handler can take over a
+// blob request, it returns false to let the blob be served with range support.
+func blobServer(t *testing.T, blob []byte, handler func(w http.ResponseWriter, r *http.Request) bool) *Registry {
+	t.Helper()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Docker-Distribution-API-Version", "registry/2.0")
+		if !strings.HasPrefix(r.URL.Path, "/v2/repo/blobs/") {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		if handler != nil && handler(w, r) {
+			return
+		}
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(blob))
+	}))
+	t.Cleanup(ts.Close)
+
+	r, err := New(context.Background(), types.AuthConfig{ServerAddress: ts.URL}, Opt{
+		FetchChunkSize: 1000,
+		Parallelism:    4,
+		Timeout:        time.Second,
+	})
+	if err != nil {
+		t.Fatalf("expected no error creating client, got %v", err)
+	}
+	return r
+}
+
+func testBlob(size int) ([]byte, distribution.Descriptor) {
+	blob := make([]byte, size)
+	rand.New(rand.NewSource(1)).Read(blob)
+	return blob, distribution.Descriptor{Digest: digest.FromBytes(blob), Size: int64(size)}
+}
+
+func fetchBlob(t *testing.T, r *Registry, desc distribution.Descriptor) ([]byte, error) {
+	t.Helper()
+
+	f, err := os.Create(filepath.Join(t.TempDir(), "blob"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if err := r.FetchBlob(context.Background(), "repo", desc, f); err != nil {
+		return nil, err
+	}
+	b, err := os.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b, nil
+}
+
+func TestFetchBlobRanges(t *testing.T) {
+	blob, desc := testBlob(10500)
+
+	var (
+		mu     sync.Mutex
+		ranges []string
+	)
+	r := blobServer(t, blob, func(w http.ResponseWriter, r *http.Request) bool {
+		mu.Lock()
+		ranges = append(ranges, r.Header.Get("Range"))
+		mu.Unlock()
+		return false
+	})
+
+	b, err := fetchBlob(t, r, desc)
+	if err != nil {
+		t.Fatalf("FetchBlob: %v", err)
+	}
+	if !bytes.Equal(b, blob) {
+		t.Fatal("fetched blob differs")
+	}
+	if len(ranges) != 11 {
+		t.Errorf("expected 11 range requests, got %d: %v", len(ranges), ranges)
+	}
+}
+
+func TestFetchBlobResume(t *testing.T) {
+	blob, desc := testBlob(5000)
+
+	var (
+		mu      sync.Mutex
+		dropped = map[string]bool{}
+	)
+	r := blobServer(t, blob, func(w http.ResponseWriter, r *http.Request) bool {
+		rng := r.Header.Get("Range")
+		mu.Lock()
+		drop := !dropped[rng]
+		dropped[rng] = true
+		mu.Unlock()
+		if !drop {
+			return false
+		}
+
+		// Send half of the range, then drop the connection.
+		var start, end int64
+		if _, err := fmt.Sscanf(rng, "bytes=%d-%d", &start, &end); err != nil {
+			t.Errorf("unexpected range %q", rng)
+			return false
+		}
+		half := (end - start + 1) / 2
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(blob)))
+		w.Header().Set("Content-Length", fmt.Sprint(end-start+1))
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write(blob[start : start+half])
+		w.(http.Flusher).Flush()
+		panic(http.ErrAbortHandler)
+	})
+
+	b, err := fetchBlob(t, r, desc)
+	if err != nil {
+		t.Fatalf("FetchBlob: %v", err)
+	}
+	if !bytes.Equal(b, blob) {
+		t.Fatal("fetched blob differs")
+	}
+}
+
+func TestFetchBlobUnknownSizeAtChunkEnd(t *testing.T) {
+	// The blob exactly fills the first range, and the registry does not send
+	// its size, so the next range starts at the end of the blob.
+	blob, desc := testBlob(1000)
+
+	r := blobServer(t, blob, func(w http.ResponseWriter, r *http.Request) bool {
+		var start, end int64
+		if n, _ := fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &start, &end); n < 1 {
+			t.Errorf("unexpected range %q", r.Header.Get("Range"))
+			return false
+		}
+		if start >= int64(len(blob)) {
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", len(blob)))
+			w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+			return true
+		}
+		end = min(end, int64(len(blob))-1)
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/*", start, end))
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write(blob[start : end+1])
+		return true
+	})
+
+	desc.Size = -1
+	b, err := fetchBlob(t, r, desc)
+	if err != nil {
+		t.Fatalf("FetchBlob: %v", err)
+	}
+	if !bytes.Equal(b, blob) {
+		t.Fatal("fetched blob differs")
+	}
+}
+
+func TestFetchBlobWithoutRanges(t *testing.T) {
+	blob, desc := testBlob(5000)
+
+	r := blobServer(t, blob, func(w http.ResponseWriter, r *http.Request) bool {
+		w.Write(blob)
+		return true
+	})
+
+	desc.Size = -1
+	b, err := fetchBlob(t, r, desc)
+	if err != nil {
+		t.Fatalf("FetchBlob: %v", err)
+	}
+	if !bytes.Equal(b, blob) {
+		t.Fatal("fetched blob differs")
+	}
+}
+
+func TestFetchBlobDigestMismatch(t *testing.T) {
+	blob, desc := testBlob(5000)
+	corrupt := append([]byte{}, blob...)
+	corrupt[4000] ^= 0xff
+
+	r := blobServer(t, corrupt, nil)
+	if _, err := fetchBlob(t, r, desc); !errors.Is(err, ErrDigestMismatch) {
+		t.Fatalf("expected ErrDigestMismatch, got %v", err)
+	}
+}
+
+func TestFetchBlobReader(t *testing.T) {
+	blob, desc := testBlob(2500)
+	r := blobServer(t, blob, nil)
+
+	rc, err := r.FetchBlobReader(context.Background(), "repo", desc)
+	if err != nil {
+		t.Fatalf("FetchBlobReader: %v", err)
+	}
+	b, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := rc.(*tempFile).Name()
+	rc.Close()
+
+	if !bytes.Equal(b, blob) {
+		t.Fatal("fetched blob differs")
+	}
+	if _, err := os.Stat(name); !os.IsNotExist(err) {
+		t.Errorf("expected the temporary file to be removed, got %v", err)
+	}
+}
+
+func TestParseContentRange(t *testing.T) {
+	start, end, size, err := parseContentRange("bytes 100-199/1000")
+	if err != nil || start != 100 || end != 199 || size != 1000 {
+		t.Errorf("got %d-%d/%d, %v", start, end, size, err)
+	}
+	if _, _, size, err := parseContentRange("bytes 0-9/*"); err != nil || size != -1 {
+		t.Errorf("expected an unknown size, got %d, %v", size, err)
+	}
+	for _, v := range []string{"", "bytes */1000", "items 0-9/10", "bytes 9-0/10"} {
+		if _, _, _, err := parseContentRange(v); err == nil {
+			t.Errorf("expected an error for %q", v)
+		}
+	}
+}
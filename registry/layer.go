@@ -9,14 +9,32 @@ import (
 
 	"fmt"
 
+	"github.com/distribution/distribution/v3"
 	"github.com/distribution/reference"
 	"github.com/opencontainers/go-digest"
 )
 
 // DownloadLayer downloads a specific layer by digest for a repository.
+// The content is verified against the digest while it is read, see VerifyReader.
 func (r *Registry) DownloadLayer(ctx context.Context, repository string, digest digest.Digest) (io.ReadCloser, error) {
-	url := r.url("/v2/%s/blobs/%s", repository, digest)
-	r.Logf("registry.layer.download url=%s repository=%s digest=%s", url, repository, digest)
+	return r.DownloadBlob(ctx, repository, distribution.Descriptor{Digest: digest, Size: -1})
+}
+
+// DownloadBlob downloads the blob described by desc for a repository. The
+// content is verified against the digest and size of desc while it is read,
+// see VerifyReader. A negative size is taken from the response instead. If a
+// cache is configured, blobs are served from it and added to it once they
+// were read and verified completely.
+func (r *Registry) DownloadBlob(ctx context.Context, repository string, desc distribution.Descriptor) (io.ReadCloser, error) {
+	if r.cache != nil {
+		if f, ok := r.cache.Blob(desc.Digest); ok {
+			r.Logf("registry.layer.cached repository=%s digest=%s", repository, desc.Digest)
+			return f, nil
+		}
+	}
+
+	url := r.url("/v2/%s/blobs/%s", repository, desc.Digest)
+	r.Logf("registry.layer.download url=%s repository=%s digest=%s", url, repository, desc.Digest)
 
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
@@ -27,7 +45,25 @@ func (r *Registry) DownloadLayer(ctx context.Context, repository string, digest
 		return nil, err
 	}
 
-	return resp.Body, nil
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, fmt.Errorf("downloading blob %s failed: %w", desc.Digest, newError(resp))
+	}
+
+	size := desc.Size
+	if size < 0 {
+		size = resp.ContentLength
+	}
+
+	body, err := VerifyReader(resp.Body, desc.Digest, size)
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	if r.cache != nil {
+		body = r.cache.CacheBlob(body, desc.Digest)
+	}
+	return body, nil
 }
 
 // GetConfig get image config from registry
@@ -38,20 +74,57 @@ func (r *Registry) GetConfig(ctx context.Context, repository string, configDiges
 	if err != nil {
 		return err
 	}
+	defer body.Close()
 
-	if err := json.NewDecoder(body).Decode(response); err != nil {
+	// Read the whole blob so it is verified before it is used.
+	b, err := io.ReadAll(body)
+	if err != nil {
 		return err
 	}
 
-	return nil
+	return json.Unmarshal(b, response)
 }
 
 // UploadLayer uploads a specific layer by digest for a repository.
 func (r *Registry) UploadLayer(ctx context.Context, repository string, digest reference.Reference, content io.Reader) error {
-	uploadURL, token, err := r.initiateUpload(ctx, repository)
+	ctx = WithScopes(ctx, RepositoryScope(repository, "pull", "push"))
+	uploadURL, token, _, err := r.initiateUpload(ctx, repository, "", "")
 	if err != nil {
 		return err
 	}
+	return r.putUpload(ctx, uploadURL, token, repository, digest, content)
+}
+
+// MountLayer mounts a layer by digest from the repository from into
+// repository, which must be on the same registry. It returns true if the
+// registry mounted the blob, in which case content is never called. If the
+// registry declines the mount it opens a regular upload session instead, and
+// the layer is uploaded from content.
+func (r *Registry) MountLayer(ctx context.Context, repository, from string, digest digest.Digest, content func() (io.ReadCloser, error)) (bool, error) {
+	// The token for the upload session needs to grant pull on the source repository as well.
+	ctx = WithScopes(ctx, RepositoryScope(repository, "pull", "push"), RepositoryScope(from, "pull"))
+
+	uploadURL, token, mounted, err := r.initiateUpload(ctx, repository, from, digest)
+	if err != nil {
+		return false, err
+	}
+	if mounted {
+		return true, nil
+	}
+
+	r.Logf("registry.layer.mount declined repository=%s from=%s digest=%s", repository, from, digest)
+
+	rc, err := content()
+	if err != nil {
+		return false, err
+	}
+	defer rc.Close()
+
+	return false, r.putUpload(ctx, uploadURL, token, repository, digest, rc)
+}
+
+// putUpload completes the upload session at uploadURL with a single PUT of content.
+func (r *Registry) putUpload(ctx context.Context, uploadURL *url.URL, token, repository string, digest reference.Reference, content io.Reader) error {
 	q := uploadURL.Query()
 	q.Set("digest", digest.String())
 	uploadURL.RawQuery = q.Encode()
@@ -63,10 +136,20 @@ func (r *Registry) UploadLayer(ctx context.Context, repository string, digest re
 		return err
 	}
 	upload.Header.Set("Content-Type", "application/octet-stream")
-	upload.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	if token != "" {
+		upload.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	}
 
-	_, err = r.Client.Do(upload.WithContext(ctx))
-	return err
+	resp, err := r.Client.Do(upload.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("uploading layer %s failed: %w", digest, newError(resp))
+	}
+	return nil
 }
 
 // HasLayer returns if the registry contains the specific digest for a repository.
@@ -79,46 +162,55 @@ func (r *Registry) HasLayer(ctx context.Context, repository string, digest diges
 		return false, err
 	}
 	resp, err := r.Client.Do(req.WithContext(ctx))
-	if err == nil {
-		defer resp.Body.Close()
-		return resp.StatusCode == http.StatusOK, nil
-	}
-
-	urlErr, ok := err.(*url.Error)
-	if !ok {
-		return false, err
-	}
-	httpErr, ok := urlErr.Err.(*httpStatusError)
-	if !ok {
+	if err != nil {
 		return false, err
 	}
-	if httpErr.Response.StatusCode == http.StatusNotFound {
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
 		return false, nil
 	}
-
-	return false, err
+	return false, newError(resp)
 }
 
-func (r *Registry) initiateUpload(ctx context.Context, repository string) (*url.URL, string, error) {
+// initiateUpload starts an upload session for repository. If from is not
+// empty, the registry is asked to mount the blob with the given digest from
+// that repository instead, and the returned bool reports whether it did.
+func (r *Registry) initiateUpload(ctx context.Context, repository, from string, digest digest.Digest) (*url.URL, string, bool, error) {
 	initiateURL := r.url("/v2/%s/blobs/uploads/", repository)
+	if from != "" {
+		initiateURL += "?" + url.Values{"mount": {digest.String()}, "from": {from}}.Encode()
+	}
 	r.Logf("registry.layer.initiate-upload url=%s repository=%s", initiateURL, repository)
 
 	req, err := http.NewRequest("POST", initiateURL, nil)
 	if err != nil {
-		return nil, "", err
+		return nil, "", false, err
 	}
 	req.Header.Set("Content-Type", "application/octet-stream")
 	resp, err := r.Client.Do(req.WithContext(ctx))
 	if err != nil {
-		return nil, "", err
+		return nil, "", false, err
 	}
 	token := resp.Header.Get("Request-Token")
 	defer resp.Body.Close()
 
+	switch resp.StatusCode {
+	case http.StatusCreated:
+		// The blob was mounted.
+		return nil, token, true, nil
+	case http.StatusAccepted:
+	default:
+		return nil, token, false, fmt.Errorf("initiating upload failed: %w", newError(resp))
+	}
+
 	location := resp.Header.Get("Location")
-	locationURL, err := url.Parse(location)
+	locationURL, err := r.resolveLocation(location)
 	if err != nil {
-		return nil, token, err
+		return nil, token, false, err
 	}
-	return locationURL, token, nil
+	return locationURL, token, false, nil
 }
@@ -0,0 +1,84 @@
+package registry
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	digest "github.com/opencontainers/go-digest"
+)
+
+func TestMountLayer(t *testing.T) {
+	var (
+		scopes   []string
+		uploaded string
+	)
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Docker-Distribution-API-Version", "registry/2.0")
+		switch {
+		case r.URL.Path == "/v2/":
+			w.WriteHeader(http.StatusOK)
+		case r.URL.Path == "/token":
+			scopes = r.URL.Query()["scope"]
+			w.Write([]byte(`{"token":"abcdef1234"}`))
+		case r.Header.Get("Authorization") != "Bearer abcdef1234":
+			w.Header().Set("www-authenticate", `Bearer realm="`+ts.URL+`/token",service="test",scope="repository:dst:pull,push"`)
+			w.WriteHeader(http.StatusUnauthorized)
+		case r.Method == "POST" && r.URL.Query().Get("from") == "src":
+			w.Header().Set("Location", "/v2/dst/blobs/sha256:mounted")
+			w.WriteHeader(http.StatusCreated)
+		case r.Method == "POST":
+			w.Header().Set("Location", "/v2/dst/blobs/uploads/session")
+			w.WriteHeader(http.StatusAccepted)
+		case r.Method == "PUT" && r.URL.Path == "/v2/dst/blobs/uploads/session":
+			b, _ := io.ReadAll(r.Body)
+			uploaded = string(b)
+			w.WriteHeader(http.StatusCreated)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	ctx := context.Background()
+	r, err := New(ctx, types.AuthConfig{ServerAddress: ts.URL}, Opt{})
+	if err != nil {
+		t.Fatalf("expected no error creating client, got %v", err)
+	}
+
+	d := digest.FromString("layer")
+	content := func() (io.ReadCloser, error) {
+		return io.NopCloser(strings.NewReader("layer")), nil
+	}
+
+	mounted, err := r.MountLayer(ctx, "dst", "src", d, content)
+	if err != nil {
+		t.Fatalf("mounting layer failed: %v", err)
+	}
+	if !mounted {
+		t.Fatal("expected layer to be mounted")
+	}
+	if expected := []string{"repository:dst:pull,push", "repository:src:pull"}; !reflect.DeepEqual(scopes, expected) {
+		t.Fatalf("expected token scopes %v, got %v", expected, scopes)
+	}
+	if uploaded != "" {
+		t.Fatalf("expected no upload for a mounted layer, got %q", uploaded)
+	}
+
+	mounted, err = r.MountLayer(ctx, "dst", "other", d, content)
+	if err != nil {
+		t.Fatalf("mounting layer failed: %v", err)
+	}
+	if mounted {
+		t.Fatal("expected mount to be declined")
+	}
+	if uploaded != "layer" {
+		t.Fatalf("expected layer to be uploaded after declined mount, got %q", uploaded)
+	}
+}
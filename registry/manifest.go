@@ -3,8 +3,8 @@ package registry
 import (
 	"bytes"
 	"context"
-	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/distribution/distribution/v3"
 	"github.com/distribution/distribution/v3/manifest/ocischema"
 	ociv1 "github.com/opencontainers/image-spec/specs-go/v1"
@@ -14,6 +14,7 @@ import (
 
 	"github.com/distribution/distribution/v3/manifest/manifestlist"
 	"github.com/distribution/distribution/v3/manifest/schema2"
+	digest "github.com/opencontainers/go-digest"
 )
 
 var (
@@ -38,8 +39,24 @@ var ManifestSupportedSchemeTypes = []string{
 	ociv1.MediaTypeImageIndex,
 }
 
-// Manifest returns the manifest for a specific repository:tag.
+// Manifest returns the manifest for a specific repository:tag. If a cache is
+// configured, manifests are served from it by digest, and a cached tag is
+// only refetched if the registry reports that it moved.
 func (r *Registry) Manifest(ctx context.Context, repository, ref string) (distribution.Manifest, distribution.Descriptor, error) {
+	if r.cache != nil {
+		d, err := digest.Parse(ref)
+		ok := err == nil
+		if !ok {
+			d, ok = r.cachedTagDigest(ctx, repository, ref)
+		}
+		if ok {
+			if mediaType, payload, ok := r.cache.Manifest(d); ok {
+				r.Logf("registry.manifests.cached repository=%s ref=%s digest=%s", repository, ref, d)
+				return distribution.UnmarshalManifest(mediaType, payload)
+			}
+		}
+	}
+
 	uri := r.url("/v2/%s/manifests/%s", repository, ref)
 	r.Logf("registry.manifests uri=%s repository=%s ref=%s", uri, repository, ref)
 
@@ -60,6 +77,10 @@ func (r *Registry) Manifest(ctx context.Context, repository, ref string) (distri
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK {
+		return nil, emptyDesc, newError(resp)
+	}
+
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return nil, emptyDesc, err
@@ -72,9 +93,28 @@ func (r *Registry) Manifest(ctx context.Context, repository, ref string) (distri
 		return nil, emptyDesc, err
 	}
 
+	if r.cache != nil {
+		r.cacheManifest(repository, ref, contentType, body)
+	}
+
 	return m, d, nil
 }
 
+// cacheManifest stores a fetched manifest, and the digest ref points at if
+// it is a tag.
+func (r *Registry) cacheManifest(repository, ref, mediaType string, payload []byte) {
+	d := digest.FromBytes(payload)
+	if err := r.cache.PutManifest(d, mediaType, payload); err != nil {
+		r.Logf("registry.cache.put repository=%s digest=%s err=%v", repository, d, err)
+		return
+	}
+	if _, err := digest.Parse(ref); err != nil {
+		if err := r.cache.PutTag(r.Domain, repository, ref, d); err != nil {
+			r.Logf("registry.cache.put repository=%s tag=%s err=%v", repository, ref, err)
+		}
+	}
+}
+
 // ManifestList gets the registry v2 manifest **list**
 // single arch image does not have a manifest list
 func (r *Registry) ManifestList(ctx context.Context, repository, ref string) (manifestlist.ManifestList, error) {
@@ -129,25 +169,49 @@ func (r *Registry) ManifestV2(ctx context.Context, repository, ref string) (sche
 	return m, nil
 }
 
-// PutManifest calls a PUT for the specific manifest for an image.
-func (r *Registry) PutManifest(ctx context.Context, repository, ref string, manifest distribution.Manifest) error {
+// PutManifest pushes a manifest, as returned by Manifest, for an image.
+// The canonical payload is pushed unchanged with its own media type, so the
+// digest of the manifest is preserved. It returns the digest reported by the
+// registry.
+func (r *Registry) PutManifest(ctx context.Context, repository, ref string, manifest distribution.Manifest) (digest.Digest, error) {
+	mediaType, payload, err := manifest.Payload()
+	if err != nil {
+		return "", err
+	}
+
+	return r.PutManifestRaw(ctx, repository, ref, mediaType, payload)
+}
+
+// PutManifestRaw pushes the raw bytes of a manifest with the given media type
+// for an image. It returns the digest reported by the registry.
+func (r *Registry) PutManifestRaw(ctx context.Context, repository, ref, mediaType string, payload []byte) (digest.Digest, error) {
 	url := r.url("/v2/%s/manifests/%s", repository, ref)
-	r.Logf("registry.manifest.put url=%s repository=%s reference=%s", url, repository, ref)
+	r.Logf("registry.manifest.put url=%s repository=%s reference=%s media_type=%s", url, repository, ref, mediaType)
 
-	b, err := json.Marshal(manifest)
-	if err != nil {
-		return err
+	if mediaType == "" {
+		return "", errors.New("manifest media type cannot be empty")
 	}
+	ctx = WithScopes(ctx, RepositoryScope(repository, "pull", "push"))
 
-	req, err := http.NewRequest("PUT", url, bytes.NewBuffer(b))
+	req, err := http.NewRequest("PUT", url, bytes.NewReader(payload))
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	req.Header.Set("Content-Type", schema2.MediaTypeManifest)
+	req.Header.Set("Content-Type", mediaType)
 	resp, err := r.Client.Do(req.WithContext(ctx))
-	if resp != nil {
-		defer resp.Body.Close()
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		return "", fmt.Errorf("putting manifest failed: %w", newError(resp))
+	}
+
+	d := resp.Header.Get("Docker-Content-Digest")
+	if d == "" {
+		return digest.FromBytes(payload), nil
 	}
-	return err
+	return digest.Parse(d)
 }
@@ -1,10 +1,17 @@
 package registry
 
 import (
+	"bytes"
 	"context"
+	"github.com/distribution/distribution/v3"
 	"github.com/distribution/distribution/v3/manifest/ocischema"
+	"github.com/docker/docker/api/types"
+	digest "github.com/opencontainers/go-digest"
 	ociv1 "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/ttys3/reg/repoutils"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 )
@@ -57,3 +64,55 @@ func TestManifestOCIDockerhubWithOciAnnotations(t *testing.T) {
 		t.Logf("imanifest: %+v", imanifest)
 	}
 }
+
+func TestPutManifestPreservesPayload(t *testing.T) {
+	// Deliberately not in canonical json.Marshal form.
+	payload := []byte(`{
+   "schemaVersion": 2,
+   "mediaType": "application/vnd.oci.image.manifest.v1+json",
+   "config": {"mediaType": "application/vnd.oci.image.config.v1+json", "digest": "sha256:a3ed95caeb02ffe68cdd9fd84406680ae93d633cb16422d00e8a7c22955b46d4", "size": 2},
+   "layers": []
+}`)
+
+	var (
+		gotType string
+		gotBody []byte
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Docker-Distribution-API-Version", "registry/2.0")
+		if r.Method == "PUT" {
+			gotType = r.Header.Get("Content-Type")
+			gotBody, _ = io.ReadAll(r.Body)
+			w.Header().Set("Docker-Content-Digest", digest.FromBytes(gotBody).String())
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	ctx := context.Background()
+	r, err := New(ctx, types.AuthConfig{ServerAddress: ts.URL}, Opt{})
+	if err != nil {
+		t.Fatalf("expected no error creating client, got %v", err)
+	}
+
+	m, desc, err := distribution.UnmarshalManifest(ociv1.MediaTypeImageManifest, payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := r.PutManifest(ctx, "foo", "latest", m)
+	if err != nil {
+		t.Fatalf("putting manifest failed: %v", err)
+	}
+	if gotType != ociv1.MediaTypeImageManifest {
+		t.Fatalf("expected content type %s, got %s", ociv1.MediaTypeImageManifest, gotType)
+	}
+	if !bytes.Equal(gotBody, payload) {
+		t.Fatalf("expected payload to be pushed unchanged, got %s", gotBody)
+	}
+	if d != desc.Digest {
+		t.Fatalf("expected digest %s, got %s", desc.Digest, d)
+	}
+}
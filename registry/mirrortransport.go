@@ -0,0 +1,145 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// mirroredPath matches the reads a mirror can serve: manifests, blobs and tag
+// lists of a repository. Everything else, like the catalog or the state of an
+// upload, only the upstream registry knows.
+var mirroredPath = regexp.MustCompile(`^/v2/.+/(manifests/[^/]+|blobs/[^/]+|tags/list)$`)
+
+// MirrorTransport defines the data structure for reading from registry
+// mirrors. Reads (GET and HEAD requests) of manifests, blobs and tag lists of
+// the upstream registry are tried against every mirror in order and fall back
+// to the upstream registry if no mirror could serve them. Writes, other reads
+// and requests with a context from WithoutMirrors always go to the upstream
+// registry.
+type MirrorTransport struct {
+	// Transport sends requests to the upstream registry.
+	Transport http.RoundTripper
+	// MirrorTransport sends requests to the mirrors. The credentials of the
+	// upstream registry are not sent to mirrors.
+	MirrorTransport http.RoundTripper
+	// Upstream is the URL of the upstream registry.
+	Upstream *url.URL
+	// Mirrors are the URLs of the mirrors, in the order they are tried.
+	Mirrors []*url.URL
+	Logf    LogfCallback
+
+	mu     sync.Mutex
+	served map[string]int
+}
+
+// RoundTrip defines the round tripper for the mirror transport.
+func (t *MirrorTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if (req.Method != "GET" && req.Method != "HEAD") || req.URL.Host != t.Upstream.Host ||
+		!mirroredPath.MatchString(req.URL.Path) || withoutMirrors(req.Context()) {
+		return t.Transport.RoundTrip(req)
+	}
+
+	for _, mirror := range t.Mirrors {
+		mreq := req.Clone(req.Context())
+		mreq.URL.Scheme = mirror.Scheme
+		mreq.URL.Host = mirror.Host
+		mreq.URL.Path = strings.TrimSuffix(mirror.Path, "/") + req.URL.Path
+		mreq.URL.RawPath = ""
+		mreq.Host = ""
+		// The upstream credentials are not meant for the mirror.
+		mreq.Header.Del("Authorization")
+
+		resp, err := t.MirrorTransport.RoundTrip(mreq)
+		if err == nil && resp.StatusCode < http.StatusBadRequest {
+			t.record(req, mirror.String())
+			return resp, nil
+		}
+
+		if err == nil {
+			resp.Body.Close()
+			err = fmt.Errorf("status %s", resp.Status)
+		}
+		t.logf("registry.mirror.fallback method=%s url=%s mirror=%s err=%v", req.Method, req.URL, mirror, err)
+	}
+
+	resp, err := t.Transport.RoundTrip(req)
+	if err == nil {
+		t.record(req, t.Upstream.String())
+	}
+	return resp, err
+}
+
+type withoutMirrorsKey struct{}
+
+// WithoutMirrors returns a copy of ctx that makes the mirror transport send
+// reads to the upstream registry. Mirrors can be stale and pull-through
+// mirrors hold blobs of every repository, so reads that decide what is written
+// to or deleted from the upstream registry must not be answered by a mirror.
+func WithoutMirrors(ctx context.Context) context.Context {
+	return context.WithValue(ctx, withoutMirrorsKey{}, true)
+}
+
+func withoutMirrors(ctx context.Context) bool {
+	without, _ := ctx.Value(withoutMirrorsKey{}).(bool)
+	return without
+}
+
+// Served returns how many read requests each endpoint, a mirror or the
+// upstream registry, served.
+func (t *MirrorTransport) Served() map[string]int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	served := make(map[string]int, len(t.served))
+	for endpoint, n := range t.served {
+		served[endpoint] = n
+	}
+	return served
+}
+
+func (t *MirrorTransport) record(req *http.Request, endpoint string) {
+	t.logf("registry.mirror method=%s path=%s endpoint=%s", req.Method, req.URL.Path, endpoint)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.served == nil {
+		t.served = map[string]int{}
+	}
+	t.served[endpoint]++
+}
+
+func (t *MirrorTransport) logf(format string, args ...interface{}) {
+	if t.Logf != nil {
+		t.Logf(format, args...)
+	}
+}
+
+// parseMirrors parses mirror addresses, which default to https unless nonSSL
+// is set.
+func parseMirrors(mirrors []string, nonSSL bool) ([]*url.URL, error) {
+	var urls []*url.URL
+	for _, m := range mirrors {
+		m = strings.TrimSuffix(strings.TrimSpace(m), "/")
+		if m == "" {
+			continue
+		}
+		if !reProtocol.MatchString(m) {
+			if nonSSL {
+				m = "http://" + m
+			} else {
+				m = "https://" + m
+			}
+		}
+		u, err := url.Parse(m)
+		if err != nil {
+			return nil, fmt.Errorf("parsing mirror %q failed: %v", m, err)
+		}
+		urls = append(urls, u)
+	}
+	return urls, nil
+}
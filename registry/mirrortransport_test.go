@@ -0,0 +1,174 @@
+package registry
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	digest "github.com/opencontainers/go-digest"
+)
+
+func TestMirrorTransport(t *testing.T) {
+	var upstreamReqs, mirrorReqs []string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamReqs = append(upstreamReqs, r.Method+" "+r.URL.Path)
+		w.Header().Set("Docker-Distribution-API-Version", "registry/2.0")
+		switch r.URL.Path {
+		case "/v2/":
+		case "/v2/foo/tags/list", "/v2/bar/tags/list":
+			w.Write([]byte(`{"tags":["upstream"]}`))
+		default:
+			w.WriteHeader(http.StatusAccepted)
+		}
+	}))
+	defer upstream.Close()
+
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer broken.Close()
+
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mirrorReqs = append(mirrorReqs, r.Method+" "+r.URL.Path)
+		if r.Header.Get("Authorization") != "" {
+			t.Errorf("expected no credentials to be sent to the mirror, got %s", r.Header.Get("Authorization"))
+		}
+		if r.URL.Path != "/v2/foo/tags/list" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`{"tags":["mirror"]}`))
+	}))
+	defer mirror.Close()
+
+	ctx := context.Background()
+	r, err := New(ctx, types.AuthConfig{ServerAddress: upstream.URL, Username: "user", Password: "secret"}, Opt{
+		Mirrors: []string{broken.URL, mirror.URL},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tags, err := r.Tags(ctx, "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tags) != 1 || tags[0] != "mirror" {
+		t.Fatalf("expected the mirror to serve the tags, got %v", tags)
+	}
+
+	// The mirror does not have bar, so the upstream serves it.
+	tags, err = r.Tags(ctx, "bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tags) != 1 || tags[0] != "upstream" {
+		t.Fatalf("expected the upstream to serve the tags, got %v", tags)
+	}
+
+	// Writes always go upstream.
+	r.initiateUpload(ctx, "foo", "", "")
+	if last := upstreamReqs[len(upstreamReqs)-1]; last != "POST /v2/foo/blobs/uploads/" {
+		t.Fatalf("expected the upload to go upstream, got %s", last)
+	}
+	for _, req := range mirrorReqs {
+		if req == "POST /v2/foo/blobs/uploads/" {
+			t.Fatal("expected the upload not to go to the mirror")
+		}
+	}
+
+	served := r.Served()
+	if served[mirror.URL] != 1 || served[upstream.URL] != 1 {
+		t.Fatalf("unexpected served counts %v", served)
+	}
+}
+
+func TestMirrorTransportUpstreamReads(t *testing.T) {
+	var (
+		mu           sync.Mutex
+		upstreamReqs []string
+		mirrorReqs   []string
+	)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		upstreamReqs = append(upstreamReqs, r.Method+" "+r.URL.Path)
+		mu.Unlock()
+		w.Header().Set("Docker-Distribution-API-Version", "registry/2.0")
+		switch {
+		case r.URL.Path == "/v2/":
+		case r.URL.Path == "/v2/_catalog":
+			w.Write([]byte(`{"repositories":["foo"]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer upstream.Close()
+
+	// A pull-through mirror has the blobs of every repository.
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		mirrorReqs = append(mirrorReqs, r.Method+" "+r.URL.Path)
+		mu.Unlock()
+		if strings.Contains(r.URL.Path, "/blobs/") {
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer mirror.Close()
+
+	ctx := context.Background()
+	r, err := New(ctx, types.AuthConfig{ServerAddress: upstream.URL}, Opt{Mirrors: []string{mirror.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := digest.FromString("blob")
+	exists, err := r.HasLayer(ctx, "foo", d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !exists {
+		t.Fatal("expected the mirror to serve the blob")
+	}
+
+	// Checks that gate an upload must not be answered by the mirror.
+	exists, err = r.HasLayer(WithoutMirrors(ctx), "foo", d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists {
+		t.Fatal("expected the upstream registry not to have the blob")
+	}
+
+	repos, err := r.Catalog(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(repos) != 1 || repos[0] != "foo" {
+		t.Fatalf("expected the upstream to serve the catalog, got %v", repos)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for _, req := range mirrorReqs {
+		if req == "GET /v2/_catalog" {
+			t.Fatal("expected the catalog not to be read from the mirror")
+		}
+	}
+	if want := "HEAD /v2/foo/blobs/" + d.String(); mirrorReqs[len(mirrorReqs)-1] != want {
+		t.Fatalf("expected %s to be the last request to the mirror, got %v", want, mirrorReqs)
+	}
+	var upstreamHeads int
+	for _, req := range upstreamReqs {
+		if req == "HEAD /v2/foo/blobs/"+d.String() {
+			upstreamHeads++
+		}
+	}
+	if upstreamHeads != 1 {
+		t.Fatalf("expected one check of the blob upstream, got %v", upstreamReqs)
+	}
+}
@@ -0,0 +1,142 @@
+package registry
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+
+	"github.com/peterhellberg/link"
+)
+
+// Pager iterates over the pages of a paginated list, the repositories of the
+// catalog or the tags of a repository. Pages are requested one at a time as
+// Next is called, so a caller can start working on the first page right away
+// and stop early by no longer calling Next.
+//
+//	p := r.CatalogPager(100, "")
+//	for p.Next(ctx) {
+//		for _, repo := range p.Page() {
+//			...
+//		}
+//	}
+//	if err := p.Err(); err != nil {
+//		...
+//	}
+type Pager struct {
+	r     *Registry
+	next  *url.URL
+	scope string
+	// items selects the items of a page from the response.
+	items func(*pageResponse) []string
+
+	page []string
+	last string
+	err  error
+}
+
+// pageResponse is the response of a paginated list.
+type pageResponse struct {
+	Repositories []string `json:"repositories"`
+	Tags         []string `json:"tags"`
+}
+
+// CatalogPager returns a Pager over the repositories of the registry. If n is
+// greater than zero the registry is asked for pages of n repositories. If
+// last is not empty the list starts after that repository, see Pager.Last.
+func (r *Registry) CatalogPager(n int, last string) *Pager {
+	return r.newPager("/v2/_catalog", n, last, CatalogScope, func(resp *pageResponse) []string {
+		return resp.Repositories
+	})
+}
+
+// TagsPager returns a Pager over the tags of a repository. n and last work
+// like they do for CatalogPager.
+func (r *Registry) TagsPager(repository string, n int, last string) *Pager {
+	return r.newPager("/v2/"+repository+"/tags/list", n, last, RepositoryScope(repository, "pull"), func(resp *pageResponse) []string {
+		return resp.Tags
+	})
+}
+
+func (r *Registry) newPager(path string, n int, last, scope string, items func(*pageResponse) []string) *Pager {
+	p := &Pager{r: r, scope: scope, items: items, last: last}
+
+	u, err := url.Parse(r.URL + path)
+	if err != nil {
+		p.err = err
+		return p
+	}
+	q := u.Query()
+	if n > 0 {
+		q.Set("n", strconv.Itoa(n))
+	}
+	if last != "" {
+		q.Set("last", last)
+	}
+	u.RawQuery = q.Encode()
+	p.next = u
+	return p
+}
+
+// Next fetches the next page. It returns false once there are no more pages
+// or a request failed, see Err.
+func (p *Pager) Next(ctx context.Context) bool {
+	if p.next == nil || p.err != nil {
+		return false
+	}
+
+	uri := p.next
+	p.next = nil
+	p.page = nil
+	p.r.Logf("registry.pager url=%s", uri)
+
+	var response pageResponse
+	h, err := p.r.getJSON(WithScopes(ctx, p.scope), uri.String(), &response)
+	if err != nil {
+		p.err = err
+		return false
+	}
+
+	p.page = p.items(&response)
+	if len(p.page) > 0 {
+		p.last = p.page[len(p.page)-1]
+	}
+
+	for _, l := range link.ParseHeader(h) {
+		if l.Rel != "next" {
+			continue
+		}
+		next, err := url.Parse(l.URI)
+		if err != nil {
+			p.err = err
+			return false
+		}
+		p.next = uri.ResolveReference(next)
+	}
+
+	return true
+}
+
+// Page returns the items of the page fetched by the last call to Next.
+func (p *Pager) Page() []string {
+	return p.page
+}
+
+// Last returns the last item returned so far. Passing it as last to a new
+// Pager resumes the list after it.
+func (p *Pager) Last() string {
+	return p.last
+}
+
+// Err returns the error that stopped Next, if any.
+func (p *Pager) Err() error {
+	return p.err
+}
+
+// all collects the items of every page.
+func (p *Pager) all(ctx context.Context) ([]string, error) {
+	var items []string
+	for p.Next(ctx) {
+		items = append(items, p.Page()...)
+	}
+	return items, p.Err()
+}
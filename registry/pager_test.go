@@ -0,0 +1,113 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+)
+
+// paginatedServer serves the catalog of repos the way the distribution
+// registry does, honoring n and last and linking to the next page.
+func paginatedServer(t *testing.T, repos []string, requests *[]string) *Registry {
+	t.Helper()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Docker-Distribution-API-Version", "registry/2.0")
+		if r.URL.Path != "/v2/_catalog" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		*requests = append(*requests, r.URL.RawQuery)
+
+		n := 2
+		if v := r.URL.Query().Get("n"); v != "" {
+			n, _ = strconv.Atoi(v)
+		}
+		last := r.URL.Query().Get("last")
+		start := sort.SearchStrings(repos, last)
+		if start < len(repos) && repos[start] == last {
+			start++
+		}
+		end := min(start+n, len(repos))
+
+		if end < len(repos) {
+			q := url.Values{"n": {strconv.Itoa(n)}, "last": {repos[end-1]}}
+			w.Header().Set("Link", fmt.Sprintf(`</v2/_catalog?%s>; rel="next"`, q.Encode()))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string][]string{"repositories": repos[start:end]})
+	}))
+	t.Cleanup(ts.Close)
+
+	r, err := New(context.Background(), types.AuthConfig{ServerAddress: ts.URL}, Opt{})
+	if err != nil {
+		t.Fatalf("expected no error creating client, got %v", err)
+	}
+	return r
+}
+
+func TestCatalogPager(t *testing.T) {
+	repos := []string{"a", "b", "c/d", "e", "f"}
+	var requests []string
+	r := paginatedServer(t, repos, &requests)
+	ctx := context.Background()
+
+	p := r.CatalogPager(2, "")
+	var pages [][]string
+	for p.Next(ctx) {
+		pages = append(pages, p.Page())
+	}
+	if err := p.Err(); err != nil {
+		t.Fatalf("pager failed: %v", err)
+	}
+	if expected := [][]string{{"a", "b"}, {"c/d", "e"}, {"f"}}; !reflect.DeepEqual(pages, expected) {
+		t.Errorf("expected pages %v, got %v", expected, pages)
+	}
+	if expected := []string{"n=2", "last=b&n=2", "last=e&n=2"}; !reflect.DeepEqual(requests, expected) {
+		t.Errorf("expected requests %v, got %v", expected, requests)
+	}
+
+	// Stop after the first page and resume from its last repository.
+	requests = nil
+	p = r.CatalogPager(3, "")
+	if !p.Next(ctx) {
+		t.Fatalf("expected a first page, got %v", p.Err())
+	}
+	if len(requests) != 1 {
+		t.Errorf("expected a single request for the first page, got %v", requests)
+	}
+
+	resumed, err := r.CatalogPager(3, p.Last()).all(ctx)
+	if err != nil {
+		t.Fatalf("resuming failed: %v", err)
+	}
+	if expected := []string{"e", "f"}; !reflect.DeepEqual(resumed, expected) {
+		t.Errorf("expected %v after %s, got %v", expected, p.Last(), resumed)
+	}
+}
+
+func TestCatalog(t *testing.T) {
+	repos := []string{"a", "b", "c", "d", "e"}
+	var requests []string
+	r := paginatedServer(t, repos, &requests)
+
+	got, err := r.Catalog(context.Background(), "")
+	if err != nil {
+		t.Fatalf("Catalog: %v", err)
+	}
+	if !reflect.DeepEqual(got, repos) {
+		t.Errorf("expected %v, got %v", repos, got)
+	}
+	if len(requests) != 3 {
+		t.Errorf("expected 3 requests, got %v", requests)
+	}
+}
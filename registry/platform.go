@@ -0,0 +1,135 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/manifest/manifestlist"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+)
+
+// ErrPlatformNotFound is returned when a manifest list does not contain a
+// manifest for the requested platform.
+var ErrPlatformNotFound = errors.New("no manifest found for platform")
+
+// DefaultPlatform is the platform selected from a manifest list when no
+// platform was requested.
+var DefaultPlatform = Platform{OS: "linux", Architecture: "amd64"}
+
+// Platform describes the operating system and architecture an image runs on.
+type Platform struct {
+	OS           string `json:"os"`
+	Architecture string `json:"architecture"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+// ParsePlatform parses a platform in the form os/arch[/variant], for example
+// linux/arm64/v8.
+func ParsePlatform(s string) (Platform, error) {
+	parts := strings.Split(strings.TrimSpace(s), "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" || strings.Contains(s, ",") {
+		return Platform{}, fmt.Errorf("invalid platform %q, expected os/arch[/variant]", s)
+	}
+
+	p := Platform{OS: parts[0], Architecture: parts[1]}
+	if len(parts) == 3 {
+		p.Variant = parts[2]
+	}
+	return p, nil
+}
+
+// ParsePlatforms parses a comma separated list of platforms.
+func ParsePlatforms(s string) ([]Platform, error) {
+	var platforms []Platform
+	for _, part := range strings.Split(s, ",") {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		p, err := ParsePlatform(part)
+		if err != nil {
+			return nil, err
+		}
+		platforms = append(platforms, p)
+	}
+	return platforms, nil
+}
+
+// String returns the os/arch[/variant] representation of the platform.
+func (p Platform) String() string {
+	if p.Variant != "" {
+		return p.OS + "/" + p.Architecture + "/" + p.Variant
+	}
+	return p.OS + "/" + p.Architecture
+}
+
+// IsZero reports whether no platform was set.
+func (p Platform) IsZero() bool {
+	return p == Platform{}
+}
+
+// Match reports whether other satisfies the platform p. An empty variant
+// in p matches any variant.
+func (p Platform) Match(other Platform) bool {
+	if p.OS != other.OS || p.Architecture != other.Architecture {
+		return false
+	}
+	return p.Variant == "" || p.Variant == other.Variant
+}
+
+// DescriptorPlatform returns the platform of a manifest list entry.
+func DescriptorPlatform(d distribution.Descriptor) Platform {
+	if d.Platform == nil {
+		return Platform{}
+	}
+	return Platform{
+		OS:           d.Platform.OS,
+		Architecture: d.Platform.Architecture,
+		Variant:      d.Platform.Variant,
+	}
+}
+
+// IsManifestList reports whether m is a docker manifest list or an OCI image index.
+func IsManifestList(m distribution.Manifest) bool {
+	switch m.(type) {
+	case *manifestlist.DeserializedManifestList, *ocischema.DeserializedImageIndex:
+		return true
+	}
+	return false
+}
+
+// ResolveManifest returns the manifest for a specific repository:tag. If the
+// reference is a manifest list or OCI index, the manifest for platform is
+// selected and returned instead. A zero platform means Opt.Platform, or
+// DefaultPlatform if that is not set either.
+func (r *Registry) ResolveManifest(ctx context.Context, repository, ref string, platform Platform) (distribution.Manifest, distribution.Descriptor, error) {
+	m, desc, err := r.Manifest(ctx, repository, ref)
+	if err != nil {
+		return nil, desc, err
+	}
+
+	if !IsManifestList(m) {
+		return m, desc, nil
+	}
+
+	if platform.IsZero() {
+		platform = r.Opt.Platform
+	}
+	if platform.IsZero() {
+		platform = DefaultPlatform
+	}
+
+	var available []string
+	for _, child := range m.References() {
+		p := DescriptorPlatform(child)
+		if platform.Match(p) {
+			r.Logf("registry.manifests.resolve repository=%s ref=%s platform=%s digest=%s", repository, ref, platform, child.Digest)
+			return r.Manifest(ctx, repository, child.Digest.String())
+		}
+		available = append(available, p.String())
+	}
+
+	return nil, desc, fmt.Errorf("%w %s in %s:%s, available: %s", ErrPlatformNotFound, platform, repository, ref, strings.Join(available, ", "))
+}
@@ -0,0 +1,94 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+	"github.com/docker/docker/api/types"
+	ociv1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestParsePlatform(t *testing.T) {
+	testcases := []struct {
+		in      string
+		want    Platform
+		wantErr bool
+	}{
+		{in: "linux/amd64", want: Platform{OS: "linux", Architecture: "amd64"}},
+		{in: "linux/arm64/v8", want: Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}},
+		{in: "linux", wantErr: true},
+		{in: "linux/arm/v7/extra", wantErr: true},
+		{in: "/amd64", wantErr: true},
+		{in: "linux/amd64,linux/arm64", wantErr: true},
+	}
+	for _, tc := range testcases {
+		got, err := ParsePlatform(tc.in)
+		if (err != nil) != tc.wantErr {
+			t.Fatalf("ParsePlatform(%q): expected error %v, got %v", tc.in, tc.wantErr, err)
+		}
+		if got != tc.want {
+			t.Fatalf("ParsePlatform(%q): expected %v, got %v", tc.in, tc.want, got)
+		}
+	}
+}
+
+const (
+	testIndex = `{
+   "schemaVersion": 2,
+   "mediaType": "application/vnd.oci.image.index.v1+json",
+   "manifests": [
+      {"mediaType": "application/vnd.oci.image.manifest.v1+json", "digest": "sha256:1111111111111111111111111111111111111111111111111111111111111111", "size": 1, "platform": {"os": "linux", "architecture": "amd64"}},
+      {"mediaType": "application/vnd.oci.image.manifest.v1+json", "digest": "sha256:2222222222222222222222222222222222222222222222222222222222222222", "size": 1, "platform": {"os": "linux", "architecture": "arm64", "variant": "v8"}}
+   ]
+}`
+	testArm64Manifest = `{
+   "schemaVersion": 2,
+   "mediaType": "application/vnd.oci.image.manifest.v1+json",
+   "config": {"mediaType": "application/vnd.oci.image.config.v1+json", "digest": "sha256:3333333333333333333333333333333333333333333333333333333333333333", "size": 2},
+   "layers": []
+}`
+)
+
+func TestResolveManifest(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Docker-Distribution-API-Version", "registry/2.0")
+		switch r.URL.Path {
+		case "/v2/":
+		case "/v2/foo/manifests/latest":
+			w.Header().Set("Content-Type", ociv1.MediaTypeImageIndex)
+			w.Write([]byte(testIndex))
+		case "/v2/foo/manifests/sha256:2222222222222222222222222222222222222222222222222222222222222222":
+			w.Header().Set("Content-Type", ociv1.MediaTypeImageManifest)
+			w.Write([]byte(testArm64Manifest))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	ctx := context.Background()
+	r, err := New(ctx, types.AuthConfig{ServerAddress: ts.URL}, Opt{Platform: Platform{OS: "linux", Architecture: "arm64"}})
+	if err != nil {
+		t.Fatalf("expected no error creating client, got %v", err)
+	}
+
+	m, _, err := r.ResolveManifest(ctx, "foo", "latest", Platform{})
+	if err != nil {
+		t.Fatalf("resolving manifest failed: %v", err)
+	}
+	om, ok := m.(*ocischema.DeserializedManifest)
+	if !ok {
+		t.Fatalf("expected an OCI manifest, got %T", m)
+	}
+	if om.Config.Digest != "sha256:3333333333333333333333333333333333333333333333333333333333333333" {
+		t.Fatalf("resolved the wrong manifest: %+v", om)
+	}
+
+	if _, _, err := r.ResolveManifest(ctx, "foo", "latest", Platform{OS: "windows", Architecture: "amd64"}); !errors.Is(err, ErrPlatformNotFound) {
+		t.Fatalf("expected ErrPlatformNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,230 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/manifest/manifestlist"
+	"github.com/distribution/distribution/v3/manifest/schema2"
+	digest "github.com/opencontainers/go-digest"
+	ociv1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// RateLimit is the pull quota a registry reported through the
+// ratelimit-limit and ratelimit-remaining headers.
+// https://docs.docker.com/docker-hub/download-rate-limit/
+type RateLimit struct {
+	// Limit is the number of pulls allowed per window.
+	Limit int
+	// Remaining is the number of pulls left in the current window.
+	Remaining int
+	// Window is the duration the limit applies to.
+	Window time.Duration
+	// Source is what the limit is counted against, for example an IP address.
+	Source string
+	// Updated is the time the headers were received.
+	Updated time.Time
+}
+
+// ParseRateLimit parses the rate limit headers of a response. It returns false
+// if the response does not carry them.
+func ParseRateLimit(h http.Header) (RateLimit, bool) {
+	limit, window, ok := parseRateLimitHeader(h.Get("ratelimit-limit"))
+	if !ok {
+		return RateLimit{}, false
+	}
+	remaining, remainingWindow, ok := parseRateLimitHeader(h.Get("ratelimit-remaining"))
+	if !ok {
+		return RateLimit{}, false
+	}
+	if window == 0 {
+		window = remainingWindow
+	}
+
+	return RateLimit{
+		Limit:     limit,
+		Remaining: remaining,
+		Window:    window,
+		Source:    h.Get("docker-ratelimit-source"),
+		Updated:   time.Now(),
+	}, true
+}
+
+// parseRateLimitHeader parses a header in the form 100;w=21600.
+func parseRateLimitHeader(v string) (int, time.Duration, bool) {
+	if v == "" {
+		return 0, 0, false
+	}
+
+	parts := strings.Split(v, ";")
+	n, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil {
+		return 0, 0, false
+	}
+
+	var window time.Duration
+	for _, p := range parts[1:] {
+		p = strings.TrimSpace(p)
+		if strings.HasPrefix(p, "w=") {
+			if s, err := strconv.Atoi(strings.TrimPrefix(p, "w=")); err == nil {
+				window = time.Duration(s) * time.Second
+			}
+		}
+	}
+	return n, window, true
+}
+
+// rateLimitState holds the last rate limit seen by a registry client.
+type rateLimitState struct {
+	mu    sync.Mutex
+	limit RateLimit
+	seen  bool
+	// taken is the number of pulls WaitRateLimit let through since the limit
+	// was received.
+	taken int
+}
+
+// RateLimitTransport defines the data structure for recording the rate limit
+// headers of registry responses.
+type RateLimitTransport struct {
+	Transport http.RoundTripper
+
+	state *rateLimitState
+}
+
+// RoundTrip defines the round tripper for the rate limit transport.
+func (t *RateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.Transport.RoundTrip(req)
+	if err != nil || t.state == nil {
+		return resp, err
+	}
+
+	if limit, ok := ParseRateLimit(resp.Header); ok {
+		t.state.mu.Lock()
+		t.state.limit = limit
+		t.state.seen = true
+		t.state.taken = 0
+		t.state.mu.Unlock()
+	}
+	return resp, err
+}
+
+// RateLimit returns the rate limit the registry reported last. It returns
+// false if no response carried rate limit headers yet.
+func (r *Registry) RateLimit() (RateLimit, bool) {
+	if r.rateLimit == nil {
+		return RateLimit{}, false
+	}
+	r.rateLimit.mu.Lock()
+	defer r.rateLimit.mu.Unlock()
+	return r.rateLimit.limit, r.rateLimit.seen
+}
+
+// WaitRateLimit blocks while the remaining pull quota is at or below
+// Opt.RateLimitReserve, so that bulk operations leave some quota for others.
+// It returns immediately if no reserve is set or no rate limit is known.
+//
+// Between responses the remaining quota is estimated from the pulls the
+// registry frees up over the window, and every return counts as a pull, so
+// that concurrent callers do not all go ahead on the same quota. Call it
+// before every request that counts against the quota.
+func (r *Registry) WaitRateLimit(ctx context.Context) error {
+	if r.Opt.RateLimitReserve <= 0 || r.rateLimit == nil {
+		return nil
+	}
+
+	for {
+		wait, ok := r.rateLimit.take(r.Opt.RateLimitReserve, time.Now())
+		if ok {
+			return nil
+		}
+		r.Logf("registry.ratelimit.wait reserve=%d wait=%s", r.Opt.RateLimitReserve, wait)
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// take counts a pull against the quota if the remaining quota at now is above
+// reserve. Otherwise it returns how long it takes until the registry has
+// freed up enough pulls.
+func (s *rateLimitState) take(reserve int, now time.Time) (time.Duration, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	limit := s.limit
+	if !s.seen || limit.Limit <= 0 || limit.Window <= 0 {
+		return 0, true
+	}
+
+	// Pulls are freed up evenly over the window.
+	interval := limit.Window / time.Duration(limit.Limit)
+	remaining := limit.Remaining - s.taken
+	if interval > 0 {
+		remaining += int(now.Sub(limit.Updated) / interval)
+	}
+	if remaining > limit.Limit {
+		remaining = limit.Limit
+	}
+
+	if remaining > reserve {
+		s.taken++
+		return 0, true
+	}
+	wait := limit.Updated.Add(interval * time.Duration(reserve-limit.Remaining+s.taken+1)).Sub(now)
+	if wait <= 0 {
+		// The reserve is more than the registry frees up.
+		wait = interval
+	}
+	return wait, false
+}
+
+// HeadManifest returns the descriptor of a manifest without fetching it. On
+// Docker Hub a HEAD request does not count against the pull quota.
+func (r *Registry) HeadManifest(ctx context.Context, repository, ref string) (distribution.Descriptor, error) {
+	uri := r.url("/v2/%s/manifests/%s", repository, ref)
+	r.Logf("registry.manifests.head url=%s repository=%s ref=%s", uri, repository, ref)
+
+	req, err := http.NewRequest("HEAD", uri, nil)
+	if err != nil {
+		return distribution.Descriptor{}, err
+	}
+
+	req.Header.Add("Accept", strings.Join([]string{
+		schema2.MediaTypeManifest,
+		manifestlist.MediaTypeManifestList,
+		ociv1.MediaTypeImageManifest,
+		ociv1.MediaTypeImageIndex,
+	}, ","))
+	resp, err := r.Client.Do(req.WithContext(ctx))
+	if err != nil {
+		return distribution.Descriptor{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return distribution.Descriptor{}, newError(resp)
+	}
+
+	desc := distribution.Descriptor{
+		MediaType: resp.Header.Get("Content-Type"),
+		Size:      resp.ContentLength,
+	}
+	if d := resp.Header.Get("Docker-Content-Digest"); d != "" {
+		if desc.Digest, err = digest.Parse(d); err != nil {
+			return desc, fmt.Errorf("parsing digest of %s:%s failed: %w", repository, ref, err)
+		}
+	}
+	return desc, nil
+}
@@ -0,0 +1,112 @@
+package registry
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+)
+
+func TestParseRateLimit(t *testing.T) {
+	h := http.Header{}
+	if _, ok := ParseRateLimit(h); ok {
+		t.Fatal("expected no rate limit without headers")
+	}
+
+	h.Set("ratelimit-limit", "100;w=21600")
+	h.Set("ratelimit-remaining", "76;w=21600")
+	h.Set("docker-ratelimit-source", "203.0.113.7")
+	limit, ok := ParseRateLimit(h)
+	if !ok {
+		t.Fatal("expected a rate limit")
+	}
+	if limit.Limit != 100 || limit.Remaining != 76 || limit.Window != 6*time.Hour || limit.Source != "203.0.113.7" {
+		t.Fatalf("unexpected rate limit %+v", limit)
+	}
+
+	h.Set("ratelimit-remaining", "many")
+	if _, ok := ParseRateLimit(h); ok {
+		t.Fatal("expected an invalid header to be ignored")
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	remaining := "76;w=21600"
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Docker-Distribution-API-Version", "registry/2.0")
+		if r.URL.Path == "/v2/test/manifests/latest" {
+			if r.Method != "HEAD" {
+				t.Errorf("expected a HEAD request, got %s", r.Method)
+			}
+			w.Header().Set("ratelimit-limit", "100;w=21600")
+			w.Header().Set("ratelimit-remaining", remaining)
+			w.Header().Set("Docker-Content-Digest", "sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855")
+			w.Header().Set("Content-Type", "application/vnd.docker.distribution.manifest.v2+json")
+		}
+	}))
+	defer ts.Close()
+
+	ctx := context.Background()
+	r, err := New(ctx, types.AuthConfig{ServerAddress: ts.URL}, Opt{RateLimitReserve: 80})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := r.RateLimit(); ok {
+		t.Fatal("expected no rate limit before the first response")
+	}
+
+	desc, err := r.HeadManifest(ctx, "test", "latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if desc.Digest.String() != "sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855" {
+		t.Fatalf("unexpected digest %s", desc.Digest)
+	}
+
+	limit, ok := r.RateLimit()
+	if !ok || limit.Remaining != 76 || limit.Limit != 100 {
+		t.Fatalf("unexpected rate limit %+v", limit)
+	}
+
+	// The quota is below the reserve, so waiting blocks until the context is done.
+	waitCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if err := r.WaitRateLimit(waitCtx); err != context.DeadlineExceeded {
+		t.Fatalf("expected the wait to be cut short, got %v", err)
+	}
+
+	remaining = "90;w=21600"
+	if _, err := r.HeadManifest(ctx, "test", "latest"); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.WaitRateLimit(waitCtx); err != nil {
+		t.Fatalf("expected no wait above the reserve, got %v", err)
+	}
+}
+
+func TestRateLimitTake(t *testing.T) {
+	now := time.Now()
+	s := &rateLimitState{
+		limit: RateLimit{Limit: 100, Remaining: 81, Window: 100 * time.Second, Updated: now},
+		seen:  true,
+	}
+
+	if _, ok := s.take(80, now); !ok {
+		t.Fatal("expected a pull above the reserve to go ahead")
+	}
+	// The pull that went ahead used up the quota above the reserve.
+	wait, ok := s.take(80, now)
+	if ok {
+		t.Fatal("expected a second pull to wait")
+	}
+	if wait != time.Second {
+		t.Fatalf("expected to wait for one pull to be freed up, got %s", wait)
+	}
+	if _, ok := s.take(80, now.Add(wait)); !ok {
+		t.Fatal("expected a pull to go ahead once the registry freed one up")
+	}
+}
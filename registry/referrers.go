@@ -0,0 +1,117 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+
+	digest "github.com/opencontainers/go-digest"
+	"github.com/peterhellberg/link"
+)
+
+// Referrer describes a manifest that refers to another manifest through its
+// subject field, such as a signature, an SBOM or an attestation.
+type Referrer struct {
+	MediaType    string            `json:"mediaType"`
+	ArtifactType string            `json:"artifactType,omitempty"`
+	Digest       digest.Digest     `json:"digest"`
+	Size         int64             `json:"size"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
+}
+
+// referrersIndex is the OCI image index returned by the referrers API and
+// stored under the referrers tag schema.
+type referrersIndex struct {
+	SchemaVersion int        `json:"schemaVersion"`
+	MediaType     string     `json:"mediaType"`
+	Manifests     []Referrer `json:"manifests"`
+}
+
+// Referrers returns the manifests that refer to the manifest with the given
+// digest, using the OCI 1.1 referrers API. Registries without that API are
+// queried through the referrers tag schema (sha256-<hex>) instead. If
+// artifactType is not empty, only referrers of that type are returned.
+// https://github.com/opencontainers/distribution-spec/blob/v1.1.0/spec.md#listing-referrers
+func (r *Registry) Referrers(ctx context.Context, repository string, dgst digest.Digest, artifactType string) ([]Referrer, error) {
+	uri := r.url("/v2/%s/referrers/%s", repository, dgst)
+	if artifactType != "" {
+		uri += "?" + url.Values{"artifactType": {artifactType}}.Encode()
+	}
+
+	var referrers []Referrer
+	filtered := true
+	for uri != "" {
+		r.Logf("registry.referrers url=%s repository=%s digest=%s", uri, repository, dgst)
+
+		var response referrersIndex
+		h, err := r.getJSON(ctx, uri, &response)
+		if err != nil {
+			if errors.Is(err, ErrResourceNotFound) && len(referrers) == 0 {
+				// The registry does not support the referrers API.
+				return r.referrersFromTag(ctx, repository, dgst, artifactType)
+			}
+			return nil, err
+		}
+
+		referrers = append(referrers, response.Manifests...)
+		if !strings.Contains(h.Get("OCI-Filters-Applied"), "artifactType") {
+			filtered = false
+		}
+
+		next := ""
+		for _, l := range link.ParseHeader(h) {
+			if l.Rel != "next" {
+				continue
+			}
+			// The link is relative to the current page, or absolute.
+			base, err := url.Parse(uri)
+			if err != nil {
+				return nil, err
+			}
+			ref, err := url.Parse(l.URI)
+			if err != nil {
+				return nil, err
+			}
+			next = base.ResolveReference(ref).String()
+		}
+		uri = next
+	}
+
+	if !filtered {
+		referrers = filterReferrers(referrers, artifactType)
+	}
+	return referrers, nil
+}
+
+// referrersFromTag returns the referrers stored in the index tagged with the
+// referrers tag schema for dgst.
+func (r *Registry) referrersFromTag(ctx context.Context, repository string, dgst digest.Digest, artifactType string) ([]Referrer, error) {
+	tag := strings.Replace(dgst.String(), ":", "-", 1)
+	uri := r.url("/v2/%s/manifests/%s", repository, tag)
+	r.Logf("registry.referrers.tag url=%s repository=%s digest=%s", uri, repository, dgst)
+
+	var response referrersIndex
+	if _, err := r.getJSON(ctx, uri, &response); err != nil {
+		if errors.Is(err, ErrResourceNotFound) {
+			// Nothing refers to the manifest.
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return filterReferrers(response.Manifests, artifactType), nil
+}
+
+func filterReferrers(referrers []Referrer, artifactType string) []Referrer {
+	if artifactType == "" {
+		return referrers
+	}
+	var filtered []Referrer
+	for _, ref := range referrers {
+		if ref.ArtifactType == artifactType {
+			filtered = append(filtered, ref)
+		}
+	}
+	return filtered
+}
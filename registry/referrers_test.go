@@ -0,0 +1,114 @@
+package registry
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	digest "github.com/opencontainers/go-digest"
+	ociv1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+const (
+	testSubject   = digest.Digest("sha256:1111111111111111111111111111111111111111111111111111111111111111")
+	testReferrers = `{
+   "schemaVersion": 2,
+   "mediaType": "application/vnd.oci.image.index.v1+json",
+   "manifests": [
+      {"mediaType": "application/vnd.oci.image.manifest.v1+json", "artifactType": "application/spdx+json", "digest": "sha256:2222222222222222222222222222222222222222222222222222222222222222", "size": 10},
+      {"mediaType": "application/vnd.oci.image.manifest.v1+json", "artifactType": "application/vnd.dev.cosign.artifact.sig.v1+json", "digest": "sha256:3333333333333333333333333333333333333333333333333333333333333333", "size": 20}
+   ]
+}`
+)
+
+func TestReferrers(t *testing.T) {
+	for _, api := range []bool{true, false} {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Docker-Distribution-API-Version", "registry/2.0")
+			switch {
+			case r.URL.Path == "/v2/":
+			case api && r.URL.Path == "/v2/foo/referrers/"+testSubject.String():
+				w.Header().Set("Content-Type", ociv1.MediaTypeImageIndex)
+				w.Write([]byte(testReferrers))
+			case !api && r.URL.Path == "/v2/foo/manifests/sha256-"+testSubject.Hex():
+				w.Header().Set("Content-Type", ociv1.MediaTypeImageIndex)
+				w.Write([]byte(testReferrers))
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+		defer ts.Close()
+
+		ctx := context.Background()
+		r, err := New(ctx, types.AuthConfig{ServerAddress: ts.URL}, Opt{})
+		if err != nil {
+			t.Fatalf("expected no error creating client, got %v", err)
+		}
+
+		referrers, err := r.Referrers(ctx, "foo", testSubject, "")
+		if err != nil {
+			t.Fatalf("api=%t: getting referrers failed: %v", api, err)
+		}
+		if len(referrers) != 2 {
+			t.Fatalf("api=%t: expected 2 referrers, got %d", api, len(referrers))
+		}
+
+		referrers, err = r.Referrers(ctx, "foo", testSubject, "application/spdx+json")
+		if err != nil {
+			t.Fatalf("api=%t: getting referrers failed: %v", api, err)
+		}
+		if len(referrers) != 1 || referrers[0].Size != 10 {
+			t.Fatalf("api=%t: expected only the sbom referrer, got %+v", api, referrers)
+		}
+
+		referrers, err = r.Referrers(ctx, "bar", testSubject, "")
+		if err != nil {
+			t.Fatalf("api=%t: getting referrers for an unknown repository failed: %v", api, err)
+		}
+		if len(referrers) != 0 {
+			t.Fatalf("api=%t: expected no referrers, got %+v", api, referrers)
+		}
+	}
+}
+
+func TestReferrersPagination(t *testing.T) {
+	for _, absolute := range []bool{false, true} {
+		var ts *httptest.Server
+		ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Docker-Distribution-API-Version", "registry/2.0")
+			switch {
+			case r.URL.Path == "/v2/":
+			case r.URL.Path == "/v2/foo/referrers/"+testSubject.String() && r.URL.Query().Get("last") == "":
+				next := "/v2/foo/referrers/" + testSubject.String() + "?last=1"
+				if absolute {
+					next = ts.URL + next
+				}
+				w.Header().Set("Link", `<`+next+`>; rel="next"`)
+				w.Header().Set("Content-Type", ociv1.MediaTypeImageIndex)
+				w.Write([]byte(testReferrers))
+			case r.URL.Path == "/v2/foo/referrers/"+testSubject.String():
+				w.Header().Set("Content-Type", ociv1.MediaTypeImageIndex)
+				w.Write([]byte(testReferrers))
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+		defer ts.Close()
+
+		ctx := context.Background()
+		r, err := New(ctx, types.AuthConfig{ServerAddress: ts.URL}, Opt{})
+		if err != nil {
+			t.Fatalf("expected no error creating client, got %v", err)
+		}
+
+		referrers, err := r.Referrers(ctx, "foo", testSubject, "")
+		if err != nil {
+			t.Fatalf("absolute=%t: getting referrers failed: %v", absolute, err)
+		}
+		if len(referrers) != 4 {
+			t.Fatalf("absolute=%t: expected 4 referrers from 2 pages, got %d", absolute, len(referrers))
+		}
+	}
+}
@@ -2,15 +2,14 @@ package registry
 
 import (
 	"context"
-	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/distribution/distribution/v3/manifest/ocischema"
 	ociv1 "github.com/opencontainers/image-spec/specs-go/v1"
-	"io"
 	"log"
 	"net/http"
+	neturl "net/url"
 	"regexp"
 	"strings"
 	"time"
@@ -23,6 +22,10 @@ import (
 var ErrResourceNotFound = errors.New("resource not found")
 var ErrBadRequest = errors.New("bad request")
 var ErrUnexpectedHttpStatusCode = errors.New("unexpected http status code")
+var ErrForbidden = errors.New("forbidden")
+var ErrUnauthorized = errors.New("unauthorized")
+var ErrTooManyRequests = errors.New("too many requests")
+var ErrUnsupported = errors.New("unsupported")
 
 // Registry defines the client for retrieving information from the registry API.
 type Registry struct {
@@ -34,6 +37,13 @@ type Registry struct {
 	PingClient *http.Client
 	Logf       LogfCallback
 	Opt        Opt
+
+	rateLimit *rateLimitState
+	cache     *Cache
+	mirrors   *MirrorTransport
+	// transport is the transport the registry clients are built on. It
+	// carries the TLS configuration of the registry.
+	transport http.RoundTripper
 }
 
 var reProtocol = regexp.MustCompile("^https?://")
@@ -58,18 +68,71 @@ type Opt struct {
 	NonSSL   bool
 	Timeout  time.Duration
 	Headers  map[string]string
+	// ChunkSize is the size of the chunks sent by UploadLayerChunked.
+	// It defaults to DefaultChunkSize.
+	ChunkSize int64
+	// Platform is the platform ResolveManifest selects from a manifest list.
+	// It defaults to DefaultPlatform.
+	Platform Platform
+	// MaxRetries is the number of times a rate limited or failed request is
+	// retried. Zero disables retries.
+	MaxRetries int
+	// RetryMinBackoff is the wait before the first retry, it doubles with
+	// every attempt. It defaults to DefaultRetryMinBackoff.
+	RetryMinBackoff time.Duration
+	// RetryMaxBackoff caps the wait between two attempts. Requests the
+	// registry asks to retry later than that are not retried. It defaults to
+	// DefaultRetryMaxBackoff.
+	RetryMaxBackoff time.Duration
+	// RateLimitReserve makes WaitRateLimit pause while the remaining pull
+	// quota is at or below this many pulls. Zero disables pausing.
+	RateLimitReserve int
+	// Parallelism is the number of concurrent requests of bulk operations
+	// run through an Executor, and of the byte ranges FetchBlob downloads at
+	// the same time. It defaults to DefaultParallelism.
+	Parallelism int
+	// FetchChunkSize is the size of the byte ranges FetchBlob downloads. It
+	// defaults to DefaultFetchChunkSize.
+	FetchChunkSize int64
+	// CacheDir is the directory of an on-disk cache for manifests and blobs,
+	// see Cache. The cache is disabled if it is empty.
+	CacheDir string
+	// Mirrors are registries tried in order for reads before the registry
+	// itself, see MirrorTransport.
+	Mirrors []string
+	// CAFile is a PEM bundle of certificate authorities trusted in addition
+	// to the system roots.
+	CAFile string
+	// CertFile and KeyFile are the PEM encoded client certificate and key
+	// presented to the registry.
+	CertFile string
+	KeyFile  string
+	// CertsDir is searched for the certificates of the registry host, see
+	// DefaultCertsDir, which it defaults to.
+	CertsDir string
 }
 
 // New creates a new Registry struct with the given URL and credentials.
 func New(ctx context.Context, auth types.AuthConfig, opt Opt) (*Registry, error) {
 	transport := http.DefaultTransport
 
-	if opt.Insecure {
-		transport = &http.Transport{
-			TLSClientConfig: &tls.Config{
-				InsecureSkipVerify: true,
-			},
-		}
+	domain := opt.Domain
+	if len(domain) < 1 || domain == "docker.io" {
+		domain = auth.ServerAddress
+	}
+	host := reProtocol.ReplaceAllString(domain, "")
+	if i := strings.Index(host, "/"); i >= 0 {
+		host = host[:i]
+	}
+
+	config, err := tlsConfig(opt, host)
+	if err != nil {
+		return nil, err
+	}
+	if config != nil {
+		t := http.DefaultTransport.(*http.Transport).Clone()
+		t.TLSClientConfig = config
+		transport = t
 	}
 
 	return newFromTransport(ctx, auth, transport, opt)
@@ -98,8 +161,29 @@ func newFromTransport(ctx context.Context, auth types.AuthConfig, transport http
 		}
 	}
 
-	tokenTransport := &TokenTransport{
+	// set the logging
+	logf := Quiet
+	if opt.Debug {
+		logf = Log
+	}
+
+	baseTransport := transport
+	transport = &RetryTransport{
+		Transport:  transport,
+		MaxRetries: opt.MaxRetries,
+		MinBackoff: opt.RetryMinBackoff,
+		MaxBackoff: opt.RetryMaxBackoff,
+		Logf:       logf,
+	}
+
+	rateLimit := &rateLimitState{}
+	rateLimitTransport := &RateLimitTransport{
 		Transport: transport,
+		state:     rateLimit,
+	}
+
+	tokenTransport := &TokenTransport{
+		Transport: rateLimitTransport,
 		Username:  auth.Username,
 		Password:  auth.Password,
 	}
@@ -112,15 +196,33 @@ func newFromTransport(ctx context.Context, auth types.AuthConfig, transport http
 	errorTransport := &ErrorTransport{
 		Transport: basicAuthTransport,
 	}
-	customTransport := &CustomTransport{
-		Transport: errorTransport,
-		Headers:   opt.Headers,
+	var upstreamTransport http.RoundTripper = errorTransport
+
+	var mirrorTransport *MirrorTransport
+	if len(opt.Mirrors) > 0 {
+		mirrors, err := parseMirrors(opt.Mirrors, opt.NonSSL)
+		if err != nil {
+			return nil, err
+		}
+		upstream, err := neturl.Parse(url)
+		if err != nil {
+			return nil, err
+		}
+		mirrorTransport = &MirrorTransport{
+			Transport: errorTransport,
+			MirrorTransport: &ErrorTransport{
+				Transport: &TokenTransport{Transport: rateLimitTransport},
+			},
+			Upstream: upstream,
+			Mirrors:  mirrors,
+			Logf:     logf,
+		}
+		upstreamTransport = mirrorTransport
 	}
 
-	// set the logging
-	logf := Quiet
-	if opt.Debug {
-		logf = Log
+	customTransport := &CustomTransport{
+		Transport: upstreamTransport,
+		Headers:   opt.Headers,
 	}
 
 	registry := &Registry{
@@ -141,6 +243,18 @@ func newFromTransport(ctx context.Context, auth types.AuthConfig, transport http
 		Password: auth.Password,
 		Logf:     logf,
 		Opt:      opt,
+
+		rateLimit: rateLimit,
+		mirrors:   mirrorTransport,
+		transport: baseTransport,
+	}
+
+	if opt.CacheDir != "" {
+		cache, err := NewCache(opt.CacheDir)
+		if err != nil {
+			return nil, err
+		}
+		registry.cache = cache
 	}
 
 	if registry.Pingable() && !opt.SkipPing {
@@ -152,6 +266,15 @@ func newFromTransport(ctx context.Context, auth types.AuthConfig, transport http
 	return registry, nil
 }
 
+// Served returns how many read requests each endpoint, a mirror or the
+// registry itself, served. It returns nil if no mirrors are configured.
+func (r *Registry) Served() map[string]int {
+	if r.mirrors == nil {
+		return nil
+	}
+	return r.mirrors.Served()
+}
+
 // url returns a registry URL with the passed arguements concatenated.
 func (r *Registry) url(pathTemplate string, args ...interface{}) string {
 	pathSuffix := fmt.Sprintf(pathTemplate, args...)
@@ -179,6 +302,8 @@ func (r *Registry) getJSON(ctx context.Context, url string, response interface{}
 		req.Header.Add("Accept", fmt.Sprintf("%s,%s", schema2.MediaTypeManifest, ociv1.MediaTypeImageManifest))
 	case *manifestlist.ManifestList:
 		req.Header.Add("Accept", fmt.Sprintf("%s,%s", manifestlist.MediaTypeManifestList, ociv1.MediaTypeImageIndex))
+	case *referrersIndex:
+		req.Header.Add("Accept", ociv1.MediaTypeImageIndex)
 	}
 
 	resp, err := r.Client.Do(req.WithContext(ctx))
@@ -189,21 +314,7 @@ func (r *Registry) getJSON(ctx context.Context, url string, response interface{}
 	r.Logf("registry.registry resp.Status=%s content_type=%s", resp.Status, resp.Header.Get("Content-Type"))
 
 	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		var wrapErr error
-		switch resp.StatusCode {
-		case http.StatusBadRequest:
-			wrapErr = ErrBadRequest
-			// 404: resource not found, body={"errors":[{"code":"MANIFEST_UNKNOWN","message":"OCI manifest found, but accept header does not support OCI manifests"}]}
-		case http.StatusNotFound:
-			wrapErr = ErrResourceNotFound
-		default:
-			wrapErr = ErrUnexpectedHttpStatusCode
-		}
-		if resp.StatusCode == http.StatusNotFound {
-			wrapErr = ErrResourceNotFound
-		}
-		return nil, fmt.Errorf("%v: %w, body=%s", resp.StatusCode, wrapErr, string(body))
+		return nil, newError(resp)
 	}
 
 	if err := json.NewDecoder(resp.Body).Decode(response); err != nil {
@@ -0,0 +1,170 @@
+package registry
+
+import (
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// DefaultRetryMinBackoff is the wait before the first retry when
+	// Opt.RetryMinBackoff is not set.
+	DefaultRetryMinBackoff = time.Second
+	// DefaultRetryMaxBackoff is the longest wait between two attempts when
+	// Opt.RetryMaxBackoff is not set.
+	DefaultRetryMaxBackoff = 30 * time.Second
+)
+
+// RetryTransport defines the data structure for retrying requests that were
+// rate limited or hit a transient server error. The wait between attempts
+// grows exponentially with jitter, a Retry-After header sent by the registry
+// is honored. Only requests with an idempotent method and a body that can be
+// replayed are retried.
+type RetryTransport struct {
+	Transport  http.RoundTripper
+	MaxRetries int
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	Logf       LogfCallback
+}
+
+// RoundTrip defines the round tripper for the retry transport.
+func (t *RetryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.MaxRetries <= 0 || !retryable(req) {
+		return t.Transport.RoundTrip(req)
+	}
+
+	for attempt := 0; ; attempt++ {
+		resp, err := t.Transport.RoundTrip(req)
+		if attempt >= t.MaxRetries {
+			return resp, err
+		}
+
+		var wait time.Duration
+		switch {
+		case err != nil:
+			if req.Context().Err() != nil {
+				return resp, err
+			}
+			wait = t.backoff(attempt)
+		case retryStatus(resp.StatusCode):
+			var ok bool
+			if wait, ok = t.retryAfter(resp, attempt); !ok {
+				return resp, err
+			}
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+			resp.Body.Close()
+		default:
+			return resp, err
+		}
+
+		if t.Logf != nil {
+			status := "error=" + errString(err)
+			if resp != nil && err == nil {
+				status = "status=" + resp.Status
+			}
+			t.Logf("registry.retry method=%s url=%s %s attempt=%d wait=%s", req.Method, req.URL, status, attempt+1, wait)
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+
+		if req.Body != nil && req.Body != http.NoBody {
+			if req.Body, err = req.GetBody(); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// retryAfter returns how long to wait before retrying resp. It returns false
+// if the registry asked to wait longer than the maximum backoff.
+func (t *RetryTransport) retryAfter(resp *http.Response, attempt int) (time.Duration, bool) {
+	h := resp.Header.Get("Retry-After")
+	if h == "" {
+		return t.backoff(attempt), true
+	}
+
+	var wait time.Duration
+	if seconds, err := strconv.Atoi(h); err == nil {
+		wait = time.Duration(seconds) * time.Second
+	} else if date, err := http.ParseTime(h); err == nil {
+		wait = time.Until(date)
+	} else {
+		return t.backoff(attempt), true
+	}
+
+	if wait < 0 {
+		wait = 0
+	}
+	if wait > t.maxBackoff() {
+		return 0, false
+	}
+	return wait, true
+}
+
+// backoff returns the jittered exponential wait before the given retry.
+func (t *RetryTransport) backoff(attempt int) time.Duration {
+	min, max := t.MinBackoff, t.maxBackoff()
+	if min <= 0 {
+		min = DefaultRetryMinBackoff
+	}
+
+	wait := min
+	for i := 0; i < attempt && wait < max; i++ {
+		wait *= 2
+	}
+	if wait > max {
+		wait = max
+	}
+
+	// Wait somewhere between half and all of the backoff, so that clients
+	// that failed together do not retry together.
+	half := wait / 2
+	return half + time.Duration(rand.Int63n(int64(wait-half)+1))
+}
+
+func (t *RetryTransport) maxBackoff() time.Duration {
+	if t.MaxBackoff <= 0 {
+		return DefaultRetryMaxBackoff
+	}
+	return t.MaxBackoff
+}
+
+// retryable reports whether req can safely be sent again.
+func retryable(req *http.Request) bool {
+	switch req.Method {
+	case "GET", "HEAD", "OPTIONS", "PUT", "DELETE":
+	default:
+		return false
+	}
+	return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+}
+
+// retryStatus reports whether a response with the status code is worth
+// retrying.
+func retryStatus(code int) bool {
+	switch code {
+	case http.StatusTooManyRequests,
+		http.StatusInternalServerError,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+func errString(err error) string {
+	if err == nil {
+		return "<nil>"
+	}
+	return err.Error()
+}
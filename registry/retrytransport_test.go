@@ -0,0 +1,105 @@
+package registry
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRetryTransport(t *testing.T) {
+	var calls int
+	var bodies []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		b, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(b))
+		switch r.URL.Path {
+		case "/flaky":
+			if calls < 3 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+		case "/ratelimited":
+			if calls < 2 {
+				w.Header().Set("Retry-After", "0")
+				w.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+		case "/later":
+			w.Header().Set("Retry-After", "3600")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		case "/broken":
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	client := &http.Client{Transport: &RetryTransport{
+		Transport:  http.DefaultTransport,
+		MaxRetries: 3,
+		MinBackoff: time.Millisecond,
+		MaxBackoff: 10 * time.Millisecond,
+	}}
+
+	for _, tc := range []struct {
+		method, path string
+		body         string
+		status       int
+		calls        int
+	}{
+		{"GET", "/flaky", "", http.StatusOK, 3},
+		{"PUT", "/flaky", "payload", http.StatusOK, 3},
+		{"GET", "/ratelimited", "", http.StatusOK, 2},
+		// Waiting an hour exceeds the maximum backoff.
+		{"GET", "/later", "", http.StatusTooManyRequests, 1},
+		// POST is not idempotent.
+		{"POST", "/flaky", "", http.StatusServiceUnavailable, 1},
+		{"GET", "/broken", "", http.StatusBadGateway, 4},
+	} {
+		calls = 0
+		bodies = nil
+
+		var body io.Reader
+		if tc.body != "" {
+			body = strings.NewReader(tc.body)
+		}
+		req, err := http.NewRequest(tc.method, ts.URL+tc.path, body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s: %v", tc.method, tc.path, err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != tc.status {
+			t.Errorf("%s %s: expected status %d, got %d", tc.method, tc.path, tc.status, resp.StatusCode)
+		}
+		if calls != tc.calls {
+			t.Errorf("%s %s: expected %d attempts, got %d", tc.method, tc.path, tc.calls, calls)
+		}
+		for _, b := range bodies {
+			if b != tc.body {
+				t.Errorf("%s %s: expected body %q to be replayed, got %q", tc.method, tc.path, tc.body, b)
+			}
+		}
+	}
+}
+
+func TestRetryTransportBackoff(t *testing.T) {
+	rt := &RetryTransport{MinBackoff: time.Second, MaxBackoff: 5 * time.Second}
+	for attempt, max := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		for i := 0; i < 10; i++ {
+			if wait := rt.backoff(attempt); wait < max/2 || wait > max {
+				t.Fatalf("attempt %d: expected a wait between %s and %s, got %s", attempt, max/2, max, wait)
+			}
+		}
+	}
+}
@@ -6,51 +6,14 @@ import (
 	"github.com/distribution/distribution/v3/manifest/schema2"
 	"github.com/opencontainers/go-digest"
 	ociv1 "github.com/opencontainers/image-spec/specs-go/v1"
-	"github.com/peterhellberg/link"
 	"github.com/sirupsen/logrus"
-	"net/url"
 	"time"
 )
 
-type tagsResponse struct {
-	Tags []string `json:"tags"`
-}
-
-// Tags returns the tags for a specific repository.
+// Tags returns the tags for a specific repository. See TagsPager to receive
+// the tags page by page.
 func (r *Registry) Tags(ctx context.Context, repository string) ([]string, error) {
-	return r.tagsWithPagination(ctx, repository, "")
-}
-
-func (r *Registry) tagsWithPagination(ctx context.Context, repository string, u string) ([]string, error) {
-	var uri string
-	if u == "" {
-		r.Logf("registry.tags url=%s repository=%s", u, repository)
-		uri = r.url("/v2/%s/tags/list", repository)
-	} else {
-		uri = r.url(u)
-	}
-
-	r.Logf("registry.tags url=%s repository=%s", uri, repository)
-
-	var response tagsResponse
-	h, err := r.getJSON(ctx, uri, &response)
-	if err != nil {
-		return nil, err
-	}
-
-	for _, l := range link.ParseHeader(h) {
-		if l.Rel == "next" {
-			logrus.Infof("begin fetch next page, repo:%s, link: %s", repository, l.URI)
-			unescaped, _ := url.QueryUnescape(l.URI)
-			tags, err := r.tagsWithPagination(ctx, repository, unescaped)
-			if err != nil {
-				return nil, err
-			}
-			response.Tags = append(response.Tags, tags...)
-		}
-	}
-
-	return response.Tags, nil
+	return r.TagsPager(repository, 0, "").all(ctx)
 }
 
 type configObjectBase struct {
@@ -69,7 +32,7 @@ func (r *Registry) CreatedDate(ctx context.Context, repository string, configDig
 
 func (r *Registry) TagCreatedDate(ctx context.Context, repo, tag string) (createdDate *time.Time, imageType string, imageSize int64, retErr error) {
 	imageType = "Docker v1"
-	manifest, descriptor, err := r.Manifest(ctx, repo, tag)
+	manifest, descriptor, err := r.ResolveManifest(ctx, repo, tag, Platform{})
 	if err != nil {
 		logrus.Errorf("getting v2 or oci manifest for %s:%s failed: %v", repo, tag, err)
 		retErr = err
@@ -0,0 +1,122 @@
+package registry
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultCertsDir is the directory searched for the certificates of a
+// registry when Opt.CertsDir is not set. It uses the layout of the docker
+// daemon: <dir>/<host>/ holds CA certificates as *.crt files and client
+// certificates as *.cert files next to *.key files of the same name.
+const DefaultCertsDir = "/etc/docker/certs.d"
+
+// tlsConfig returns the TLS configuration for the registry at host, or nil if
+// the defaults apply.
+func tlsConfig(opt Opt, host string) (*tls.Config, error) {
+	var (
+		cas   []string
+		pairs [][2]string
+	)
+
+	// Discover the certificates in the certs directory of the host.
+	certsDir := opt.CertsDir
+	if certsDir == "" {
+		certsDir = DefaultCertsDir
+	}
+	if host != "" {
+		var err error
+		cas, pairs, err = discoverCerts(filepath.Join(certsDir, host))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if opt.CAFile != "" {
+		cas = append(cas, opt.CAFile)
+	}
+	if opt.CertFile != "" || opt.KeyFile != "" {
+		if opt.CertFile == "" || opt.KeyFile == "" {
+			return nil, fmt.Errorf("a client certificate needs both a certificate and a key file")
+		}
+		// An explicit client certificate replaces discovered ones.
+		pairs = [][2]string{{opt.CertFile, opt.KeyFile}}
+	}
+
+	if !opt.Insecure && len(cas) == 0 && len(pairs) == 0 {
+		return nil, nil
+	}
+
+	config := &tls.Config{
+		InsecureSkipVerify: opt.Insecure,
+	}
+
+	if len(cas) > 0 {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		for _, ca := range cas {
+			pem, err := os.ReadFile(ca)
+			if err != nil {
+				return nil, fmt.Errorf("reading CA bundle failed: %v", err)
+			}
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no certificates found in CA bundle %s", ca)
+			}
+		}
+		config.RootCAs = pool
+	}
+
+	for _, pair := range pairs {
+		cert, err := tls.LoadX509KeyPair(pair[0], pair[1])
+		if err != nil {
+			return nil, fmt.Errorf("loading client certificate failed: %v", err)
+		}
+		config.Certificates = append(config.Certificates, cert)
+	}
+
+	return config, nil
+}
+
+// discoverCerts lists the CA certificates and client certificate and key
+// pairs in dir. A missing directory has no certificates.
+func discoverCerts(dir string) ([]string, [][2]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil, nil
+		}
+		return nil, nil, fmt.Errorf("reading certs directory failed: %v", err)
+	}
+
+	var (
+		cas   []string
+		pairs [][2]string
+	)
+	for _, e := range entries {
+		name := e.Name()
+		switch filepath.Ext(name) {
+		case ".crt":
+			cas = append(cas, filepath.Join(dir, name))
+		case ".cert":
+			key := strings.TrimSuffix(name, ".cert") + ".key"
+			if _, err := os.Stat(filepath.Join(dir, key)); err != nil {
+				return nil, nil, fmt.Errorf("missing key %s for client certificate %s", key, filepath.Join(dir, name))
+			}
+			pairs = append(pairs, [2]string{filepath.Join(dir, name), filepath.Join(dir, key)})
+		case ".key":
+			cert := strings.TrimSuffix(name, ".key") + ".cert"
+			if _, err := os.Stat(filepath.Join(dir, cert)); err != nil {
+				return nil, nil, fmt.Errorf("missing client certificate %s for key %s", cert, filepath.Join(dir, name))
+			}
+		}
+	}
+	return cas, pairs, nil
+}
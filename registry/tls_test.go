@@ -0,0 +1,142 @@
+package registry
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+)
+
+// testCert creates a certificate signed by parent, or a self-signed CA if
+// parent is nil, and returns it with its PEM encoding.
+func testCert(t *testing.T, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey, []byte, []byte) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+		parent, parentKey = tmpl, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key,
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func TestTLSCertsDir(t *testing.T) {
+	ca, caKey, caPEM, _ := testCert(t, "test ca", nil, nil)
+	_, _, serverPEM, serverKeyPEM := testCert(t, "server", ca, caKey)
+	_, _, clientPEM, clientKeyPEM := testCert(t, "client", ca, caKey)
+
+	serverCert, err := tls.X509KeyPair(serverPEM, serverKeyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Docker-Distribution-API-Version", "registry/2.0")
+		w.WriteHeader(http.StatusOK)
+	}))
+	ts.TLS = &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}
+	ts.StartTLS()
+	defer ts.Close()
+
+	host := strings.TrimPrefix(ts.URL, "https://")
+	certsDir := t.TempDir()
+	dir := filepath.Join(certsDir, host)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, b := range map[string][]byte{
+		"ca.crt":      caPEM,
+		"client.cert": clientPEM,
+		"client.key":  clientKeyPEM,
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), b, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Without the certs directory the server is not trusted.
+	auth := types.AuthConfig{ServerAddress: ts.URL}
+	if _, err := New(context.Background(), auth, Opt{CertsDir: t.TempDir()}); err == nil {
+		t.Fatal("expected the ping to fail without the CA")
+	}
+
+	r, err := New(context.Background(), auth, Opt{CertsDir: certsDir})
+	if err != nil {
+		t.Fatalf("New with certs dir: %v", err)
+	}
+
+	// The token client uses the same certificates.
+	if _, err := r.Token(context.Background(), ts.URL+"/v2/"); err != nil {
+		t.Fatalf("Token: %v", err)
+	}
+}
+
+func TestTLSConfigMissingKey(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "registry.example.com"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "registry.example.com", "client.cert"), nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := tlsConfig(Opt{CertsDir: dir}, "registry.example.com"); err == nil {
+		t.Error("expected an error for a client certificate without a key")
+	}
+	if _, err := tlsConfig(Opt{CertFile: "client.cert"}, ""); err == nil {
+		t.Error("expected an error for a certificate file without a key file")
+	}
+	config, err := tlsConfig(Opt{CertsDir: dir}, "other.example.com")
+	if err != nil || config != nil {
+		t.Errorf("expected the default configuration, got %v, %v", config, err)
+	}
+}
@@ -0,0 +1,146 @@
+package registry
+
+import (
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultTokenLifetime is the lifetime of a token without expires_in, as
+	// defined by the token authentication specification.
+	defaultTokenLifetime = 60 * time.Second
+	// tokenExpiryLeeway is subtracted from the token lifetime so that tokens
+	// are not used right before they expire.
+	tokenExpiryLeeway = 10 * time.Second
+)
+
+// cachedToken is a bearer token together with the time it expires.
+type cachedToken struct {
+	token  string
+	expiry time.Time
+}
+
+// tokenChallenge is the token server a registry host sent clients to.
+type tokenChallenge struct {
+	realm   string
+	service string
+}
+
+// tokenKey returns the cache key for a token issued by realm and service for
+// the given scopes.
+func tokenKey(realm, service string, scopes []string) string {
+	merged := mergeScopes(scopes)
+	normalized := make([]string, 0, len(merged))
+	for _, s := range merged {
+		normalized = append(normalized, normalizeScope(s))
+	}
+	sort.Strings(normalized)
+	return realm + " " + service + " " + strings.Join(normalized, " ")
+}
+
+// normalizeScope sorts the actions of a resource scope, so that
+// "repository:foo:push,pull" and "repository:foo:pull,push" are equal.
+func normalizeScope(scope string) string {
+	i := strings.LastIndex(scope, ":")
+	if i < 0 {
+		return scope
+	}
+	actions := strings.Split(scope[i+1:], ",")
+	sort.Strings(actions)
+	return scope[:i+1] + strings.Join(actions, ",")
+}
+
+// requestScope guesses the scope a registry will demand for req, so that a
+// cached token can be sent along with the first attempt.
+func requestScope(req *http.Request) string {
+	path := req.URL.Path
+	if path == "/v2/_catalog" {
+		return "registry:catalog:*"
+	}
+	if !strings.HasPrefix(path, "/v2/") {
+		return ""
+	}
+	path = strings.TrimPrefix(path, "/v2/")
+
+	end := -1
+	for _, sep := range []string{"/manifests/", "/blobs/", "/tags/", "/referrers/"} {
+		if i := strings.Index(path, sep); i > 0 && (end < 0 || i < end) {
+			end = i
+		}
+	}
+	if end < 0 {
+		return ""
+	}
+
+	action := "pull"
+	switch req.Method {
+	case "GET", "HEAD":
+	case "DELETE":
+		action = "delete"
+	default:
+		action = "pull,push"
+	}
+	return "repository:" + path[:end] + ":" + action
+}
+
+// expiry returns the time the token expires.
+func (t authToken) expiry(now time.Time) time.Time {
+	issued := now
+	if !t.IssuedAt.IsZero() {
+		issued = t.IssuedAt
+	}
+	lifetime := time.Duration(t.ExpiresIn) * time.Second
+	if lifetime < defaultTokenLifetime {
+		lifetime = defaultTokenLifetime
+	}
+	return issued.Add(lifetime - tokenExpiryLeeway)
+}
+
+// cachedToken returns a valid cached token for req, if there is one.
+func (t *TokenTransport) cachedToken(req *http.Request) (string, bool) {
+	scope := requestScope(req)
+	if scope == "" {
+		return "", false
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	challenge, ok := t.challenges[req.URL.Host]
+	if !ok {
+		return "", false
+	}
+
+	key := tokenKey(challenge.realm, challenge.service, append([]string{scope}, scopesFromContext(req.Context())...))
+	ct, ok := t.tokens[key]
+	if !ok {
+		return "", false
+	}
+	if !time.Now().Before(ct.expiry) {
+		delete(t.tokens, key)
+		return "", false
+	}
+	return ct.token, true
+}
+
+// storeToken caches a token issued for the auth challenge a host sent.
+func (t *TokenTransport) storeToken(host string, a *authService, token string, expiry time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.challenges == nil {
+		t.challenges = map[string]tokenChallenge{}
+	}
+	if t.tokens == nil {
+		t.tokens = map[string]cachedToken{}
+	}
+
+	// The realm query is rewritten by authService.Request, leave it out.
+	realm := url.URL{Scheme: a.Realm.Scheme, Host: a.Realm.Host, Path: a.Realm.Path}
+	c := tokenChallenge{realm: realm.String(), service: a.Service}
+	t.challenges[host] = c
+	t.tokens[tokenKey(c.realm, c.service, a.Scope)] = cachedToken{token: token, expiry: expiry}
+}
@@ -2,7 +2,6 @@ package registry
 
 import (
 	"context"
-	"crypto/tls"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
@@ -10,19 +9,34 @@ import (
 	"net/http"
 	"net/url"
 	"regexp"
+	"strings"
+	"sync"
+	"time"
 )
 
 var gcrMatcher = regexp.MustCompile(`https://([a-z]+\.|)gcr\.io/`)
 
 // TokenTransport defines the data structure for authentication via tokens.
+// Tokens are cached by realm, service and scope until they expire, and a
+// cached token is sent along with the first attempt of a request.
 type TokenTransport struct {
 	Transport http.RoundTripper
 	Username  string
 	Password  string
+
+	mu         sync.Mutex
+	challenges map[string]tokenChallenge
+	tokens     map[string]cachedToken
 }
 
 // RoundTrip defines the round tripper for token transport.
 func (t *TokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, cached := t.cachedToken(req)
+	if cached {
+		req = req.Clone(req.Context())
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	}
+
 	resp, err := t.Transport.RoundTrip(req)
 	if err != nil {
 		return resp, err
@@ -35,6 +49,9 @@ func (t *TokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
 	}
 
 	if authService == nil {
+		if cached {
+			resp.Header.Set("request-token", token)
+		}
 		return resp, nil
 	}
 
@@ -44,8 +61,10 @@ func (t *TokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
 }
 
 type authToken struct {
-	Token       string `json:"token"`
-	AccessToken string `json:"access_token"`
+	Token       string    `json:"token"`
+	AccessToken string    `json:"access_token"`
+	ExpiresIn   int       `json:"expires_in"`
+	IssuedAt    time.Time `json:"issued_at"`
 }
 
 func (t authToken) String() (string, error) {
@@ -59,7 +78,7 @@ func (t authToken) String() (string, error) {
 }
 
 func (t *TokenTransport) authAndRetry(authService *authService, req *http.Request) (*http.Response, error) {
-	token, authResp, err := t.auth(req.Context(), authService)
+	token, authResp, err := t.auth(req.Context(), req.URL.Host, authService)
 	if err != nil {
 		return authResp, err
 	}
@@ -71,7 +90,9 @@ func (t *TokenTransport) authAndRetry(authService *authService, req *http.Reques
 	return response, err
 }
 
-func (t *TokenTransport) auth(ctx context.Context, authService *authService) (string, *http.Response, error) {
+func (t *TokenTransport) auth(ctx context.Context, host string, authService *authService) (string, *http.Response, error) {
+	authService.Scope = append(authService.Scope, scopesFromContext(ctx)...)
+
 	authReq, err := authService.Request(t.Username, t.Password)
 	if err != nil {
 		return "", nil, err
@@ -88,7 +109,7 @@ func (t *TokenTransport) auth(ctx context.Context, authService *authService) (st
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return "", resp, err
+		return "", nil, fmt.Errorf("getting token failed: %w", newError(resp))
 	}
 
 	var authToken authToken
@@ -97,14 +118,53 @@ func (t *TokenTransport) auth(ctx context.Context, authService *authService) (st
 	}
 
 	token, err := authToken.String()
-	return token, nil, err
+	if err != nil {
+		return "", nil, err
+	}
+
+	t.storeToken(host, authService, token, authToken.expiry(time.Now()))
+	return token, nil, nil
 }
 
 func (t *TokenTransport) retry(req *http.Request, token string) (*http.Response, error) {
+	// The first attempt consumed the body, get a fresh copy if we can.
+	if req.Body != nil && req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = body
+	}
 	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
 	return t.Transport.RoundTrip(req)
 }
 
+type scopeKey struct{}
+
+// CatalogScope is the token scope needed to list the repositories of a registry.
+const CatalogScope = "registry:catalog:*"
+
+// RepositoryScope returns the token scope for the given actions on a
+// repository, for example repository:foo:pull,push.
+func RepositoryScope(repository string, actions ...string) string {
+	return fmt.Sprintf("repository:%s:%s", repository, strings.Join(actions, ","))
+}
+
+// WithScopes returns a copy of ctx that makes the token transport request the
+// given scopes in addition to the ones from the auth challenge. Registries
+// often only advertise the scope of the request that was rejected, so
+// operations that touch several repositories or need more than pull access
+// should ask for their scopes up front.
+func WithScopes(ctx context.Context, scopes ...string) context.Context {
+	s := append([]string{}, scopesFromContext(ctx)...)
+	return context.WithValue(ctx, scopeKey{}, append(s, scopes...))
+}
+
+func scopesFromContext(ctx context.Context) []string {
+	scopes, _ := ctx.Value(scopeKey{}).([]string)
+	return scopes
+}
+
 type authService struct {
 	Realm   *url.URL
 	Service string
@@ -114,10 +174,10 @@ type authService struct {
 func (a *authService) Request(username, password string) (*http.Request, error) {
 	q := a.Realm.Query()
 	q.Set("service", a.Service)
-	for _, s := range a.Scope {
-		q.Set("scope", s)
+	q.Del("scope")
+	for _, s := range mergeScopes(a.Scope) {
+		q.Add("scope", s)
 	}
-	//	q.Set("scope", "repository:r.j3ss.co/htop:push,pull")
 	a.Realm.RawQuery = q.Encode()
 
 	req, err := http.NewRequest("GET", a.Realm.String(), nil)
@@ -129,6 +189,44 @@ func (a *authService) Request(username, password string) (*http.Request, error)
 	return req, err
 }
 
+// mergeScopes merges the actions of scopes for the same resource, so that
+// repository:foo:pull and repository:foo:push become repository:foo:pull,push.
+// The order in which resources first appear is kept.
+func mergeScopes(scopes []string) []string {
+	var resources []string
+	actions := map[string][]string{}
+	for _, scope := range scopes {
+		resource, list := scope, ""
+		if i := strings.LastIndex(scope, ":"); i >= 0 {
+			resource, list = scope[:i], scope[i+1:]
+		}
+		if _, ok := actions[resource]; !ok {
+			resources = append(resources, resource)
+			actions[resource] = []string{}
+		}
+		for _, action := range strings.Split(list, ",") {
+			if action != "" && !containsString(actions[resource], action) {
+				actions[resource] = append(actions[resource], action)
+			}
+		}
+	}
+
+	merged := make([]string, 0, len(resources))
+	for _, resource := range resources {
+		merged = append(merged, resource+":"+strings.Join(actions[resource], ","))
+	}
+	return merged
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
 func isTokenDemand(resp *http.Response) (*authService, error) {
 	if resp == nil {
 		return nil, nil
@@ -149,16 +247,11 @@ func (r *Registry) Token(ctx context.Context, url string) (string, error) {
 		return "", err
 	}
 
-	client := http.DefaultClient
-	if r.Opt.Insecure {
-		client = &http.Client{
-			Timeout: r.Opt.Timeout,
-			Transport: &http.Transport{
-				TLSClientConfig: &tls.Config{
-					InsecureSkipVerify: true,
-				},
-			},
-		}
+	// Use the TLS configuration of the registry, the token endpoints are
+	// usually behind the same CA.
+	client := &http.Client{
+		Timeout:   r.Opt.Timeout,
+		Transport: r.transport,
 	}
 
 	resp, err := client.Do(req.WithContext(ctx))
@@ -188,14 +281,14 @@ func (r *Registry) Token(ctx context.Context, url string) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	resp, err = http.DefaultClient.Do(authReq.WithContext(ctx))
+	resp, err = client.Do(authReq.WithContext(ctx))
 	if err != nil {
 		return "", err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("getting token failed with StatusCode != StatusOK but %d", resp.StatusCode)
+		return "", fmt.Errorf("getting token failed: %w", newError(resp))
 	}
 
 	var authToken authToken
@@ -5,8 +5,11 @@ import (
 	"errors"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/docker/docker/api/types"
 )
@@ -196,3 +199,115 @@ func TestTokenTransportAuthLeak(t *testing.T) {
 		t.Fatal("Expected body to be closed")
 	}
 }
+
+func TestTokenTransportCachesTokens(t *testing.T) {
+	var tokenRequests, unauthorized int
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Docker-Distribution-API-Version", "registry/2.0")
+		if r.URL.Path == "/token" {
+			tokenRequests++
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"token":"` + r.URL.Query().Get("scope") + `","expires_in":300}`))
+			return
+		}
+		if r.URL.Path == "/v2/" {
+			return
+		}
+
+		repo := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/v2/"), "/tags/list")
+		scope := "repository:" + repo + ":pull"
+		if r.Header.Get("Authorization") != "Bearer "+scope {
+			unauthorized++
+			w.Header().Set("www-authenticate", `Bearer realm="`+ts.URL+`/token",service="test",scope="`+scope+`"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"name":"` + repo + `","tags":["latest"]}`))
+	}))
+	defer ts.Close()
+
+	ctx := context.Background()
+	r, err := New(ctx, types.AuthConfig{ServerAddress: ts.URL}, Opt{NonSSL: true, SkipPing: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := r.Tags(ctx, "foo"); err != nil {
+			t.Fatalf("listing tags failed: %v", err)
+		}
+	}
+	if tokenRequests != 1 || unauthorized != 1 {
+		t.Fatalf("expected 1 token request and 1 challenge, got %d and %d", tokenRequests, unauthorized)
+	}
+
+	// Another repository needs a token with another scope.
+	if _, err := r.Tags(ctx, "bar"); err != nil {
+		t.Fatalf("listing tags failed: %v", err)
+	}
+	if _, err := r.Tags(ctx, "foo"); err != nil {
+		t.Fatalf("listing tags failed: %v", err)
+	}
+	if tokenRequests != 2 || unauthorized != 2 {
+		t.Fatalf("expected 2 token requests and 2 challenges, got %d and %d", tokenRequests, unauthorized)
+	}
+}
+
+func TestAuthTokenExpiry(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		token authToken
+		want  time.Time
+	}{
+		{authToken{}, now.Add(defaultTokenLifetime - tokenExpiryLeeway)},
+		{authToken{ExpiresIn: 10}, now.Add(defaultTokenLifetime - tokenExpiryLeeway)},
+		{authToken{ExpiresIn: 300}, now.Add(300*time.Second - tokenExpiryLeeway)},
+		{authToken{ExpiresIn: 300, IssuedAt: now.Add(-time.Minute)}, now.Add(240*time.Second - tokenExpiryLeeway)},
+	} {
+		if got := tc.token.expiry(now); !got.Equal(tc.want) {
+			t.Errorf("expiry of %+v: expected %s, got %s", tc.token, tc.want, got)
+		}
+	}
+}
+
+func TestAuthServiceRequestScopes(t *testing.T) {
+	realm, _ := url.Parse("https://auth.example.com/token?scope=stale")
+	a := &authService{
+		Realm:   realm,
+		Service: "registry.example.com",
+		Scope: []string{
+			"repository:foo:pull",
+			CatalogScope,
+			RepositoryScope("foo", "push", "pull"),
+			RepositoryScope("bar", "pull"),
+			RepositoryScope("foo", "delete"),
+		},
+	}
+
+	req, err := a.Request("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"repository:foo:pull,push,delete", "registry:catalog:*", "repository:bar:pull"}
+	if scopes := req.URL.Query()["scope"]; !reflect.DeepEqual(scopes, expected) {
+		t.Fatalf("expected scopes %v, got %v", expected, scopes)
+	}
+	if service := req.URL.Query().Get("service"); service != "registry.example.com" {
+		t.Fatalf("expected service registry.example.com, got %s", service)
+	}
+}
+
+func TestWithScopes(t *testing.T) {
+	ctx := WithScopes(context.Background(), CatalogScope)
+	child := WithScopes(ctx, RepositoryScope("foo", "pull"))
+
+	if scopes := scopesFromContext(ctx); !reflect.DeepEqual(scopes, []string{CatalogScope}) {
+		t.Fatalf("expected parent scopes to be unchanged, got %v", scopes)
+	}
+	expected := []string{CatalogScope, "repository:foo:pull"}
+	if scopes := scopesFromContext(child); !reflect.DeepEqual(scopes, expected) {
+		t.Fatalf("expected scopes %v, got %v", expected, scopes)
+	}
+}
@@ -0,0 +1,327 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/distribution/distribution/v3"
+	digest "github.com/opencontainers/go-digest"
+)
+
+// DefaultChunkSize is the size of the chunks sent by UploadLayerChunked when
+// Opt.ChunkSize is not set.
+const DefaultChunkSize int64 = 8 << 20
+
+// ErrUploadUnknown is returned when the registry no longer knows about an
+// upload session, for example because it expired.
+var ErrUploadUnknown = errors.New("upload session unknown")
+
+// UploadState holds the state of a resumable upload session. It can be
+// persisted by callers and passed back to UploadLayerChunked to resume an
+// interrupted upload.
+type UploadState struct {
+	// Location is the URL of the upload session.
+	Location string `json:"location"`
+	// Offset is the number of bytes the registry has accepted so far.
+	Offset int64 `json:"offset"`
+
+	token string
+}
+
+// UploadLayerChunked uploads a layer for a repository in chunks of
+// Opt.ChunkSize bytes using PATCH requests. The state is updated after every
+// accepted chunk. If state already holds a session location, the registry is
+// asked for the last accepted offset and the upload resumes from there.
+//
+// content must be positioned at the start of the blob. If it implements
+// io.Seeker it is seeked to the resume offset, otherwise the bytes before the
+// offset are read and discarded.
+func (r *Registry) UploadLayerChunked(ctx context.Context, repository string, digest digest.Digest, content io.Reader, state *UploadState) error {
+	if state == nil {
+		state = &UploadState{}
+	}
+	ctx = WithScopes(ctx, RepositoryScope(repository, "pull", "push"))
+
+	if state.Location != "" {
+		if _, err := r.UploadStatus(ctx, state); err != nil {
+			if !errors.Is(err, ErrUploadUnknown) {
+				return err
+			}
+			// The session is gone, start a new one.
+			r.Logf("registry.layer.upload-chunked session expired location=%s", state.Location)
+			*state = UploadState{}
+		}
+	}
+
+	if state.Location == "" {
+		uploadURL, token, _, err := r.initiateUpload(ctx, repository, "", "")
+		if err != nil {
+			return err
+		}
+		state.Location = uploadURL.String()
+		state.Offset = 0
+		state.token = token
+	}
+
+	if state.Offset > 0 {
+		if err := skip(content, state.Offset); err != nil {
+			return fmt.Errorf("skipping to offset %d failed: %v", state.Offset, err)
+		}
+	}
+
+	r.Logf("registry.layer.upload-chunked url=%s repository=%s digest=%s offset=%d", state.Location, repository, digest, state.Offset)
+
+	chunkSize := r.Opt.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+
+	buf := make([]byte, chunkSize)
+	for {
+		n, err := io.ReadFull(content, buf)
+		if n > 0 {
+			if err := r.uploadChunk(ctx, state, buf[:n]); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return r.completeUpload(ctx, state, digest)
+}
+
+// maxUploadResumes is the number of times in a row UploadBlob resumes an
+// upload without the registry accepting any more data before it gives up.
+const maxUploadResumes = 5
+
+// UploadBlob uploads the blob described by desc for a repository, reading it
+// from the content returned by open. Blobs up to Opt.ChunkSize bytes are
+// uploaded in a single request with UploadLayer. Larger ones, and blobs of
+// unknown size, are uploaded with UploadLayerChunked, and an upload that fails
+// on the way is resumed from the last chunk the registry accepted. open is
+// called again for every attempt and has to return the content from the
+// start.
+func (r *Registry) UploadBlob(ctx context.Context, repository string, desc distribution.Descriptor, open func() (io.ReadCloser, error)) error {
+	chunkSize := r.Opt.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+
+	if desc.Size >= 0 && desc.Size <= chunkSize {
+		rc, err := open()
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+		return r.UploadLayer(ctx, repository, desc.Digest, rc)
+	}
+
+	var (
+		state    UploadState
+		failures int
+	)
+	for {
+		rc, err := open()
+		if err != nil {
+			return err
+		}
+		offset := state.Offset
+		err = r.UploadLayerChunked(ctx, repository, desc.Digest, rc, &state)
+		rc.Close()
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if !resumable(err) {
+			return err
+		}
+
+		if state.Offset > offset {
+			failures = 0
+		} else if failures++; failures >= maxUploadResumes {
+			return err
+		}
+		r.Logf("registry.layer.upload.resume repository=%s digest=%s offset=%d err=%v", repository, desc.Digest, state.Offset, err)
+	}
+}
+
+// resumable reports whether an upload that failed with err can be resumed.
+// Requests the registry rejected and content that does not match its
+// descriptor fail the same way again.
+func resumable(err error) bool {
+	if errors.Is(err, ErrUploadUnknown) {
+		// UploadLayerChunked starts a new session.
+		return true
+	}
+	if errors.Is(err, ErrDigestMismatch) || errors.Is(err, ErrSizeMismatch) {
+		return false
+	}
+	var rerr *Error
+	return !errors.As(err, &rerr) || rerr.StatusCode >= http.StatusInternalServerError
+}
+
+// UploadStatus queries the registry for the status of the upload session in
+// state. It updates and returns the offset the upload can be resumed from.
+func (r *Registry) UploadStatus(ctx context.Context, state *UploadState) (int64, error) {
+	r.Logf("registry.layer.upload-status url=%s", state.Location)
+
+	req, err := http.NewRequest("GET", state.Location, nil)
+	if err != nil {
+		return 0, err
+	}
+	r.setUploadToken(req, state)
+
+	resp, err := r.Client.Do(req.WithContext(ctx))
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusNoContent:
+	case http.StatusNotFound:
+		return 0, fmt.Errorf("%w: %w", ErrUploadUnknown, newError(resp))
+	default:
+		return 0, fmt.Errorf("getting upload status failed: %w", newError(resp))
+	}
+
+	if err := r.updateUploadState(state, resp); err != nil {
+		return 0, err
+	}
+	return state.Offset, nil
+}
+
+func (r *Registry) uploadChunk(ctx context.Context, state *UploadState, chunk []byte) error {
+	end := state.Offset + int64(len(chunk)) - 1
+	r.Logf("registry.layer.upload-chunk url=%s range=%d-%d", state.Location, state.Offset, end)
+
+	req, err := http.NewRequest("PATCH", state.Location, bytes.NewReader(chunk))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Content-Range", fmt.Sprintf("%d-%d", state.Offset, end))
+	r.setUploadToken(req, state)
+
+	resp, err := r.Client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusAccepted, http.StatusNoContent:
+	case http.StatusNotFound:
+		return fmt.Errorf("%w: %w", ErrUploadUnknown, newError(resp))
+	default:
+		return fmt.Errorf("uploading chunk %d-%d failed: %w", state.Offset, end, newError(resp))
+	}
+
+	state.Offset = end + 1
+	return r.updateUploadState(state, resp)
+}
+
+func (r *Registry) completeUpload(ctx context.Context, state *UploadState, digest digest.Digest) error {
+	uploadURL, err := url.Parse(state.Location)
+	if err != nil {
+		return err
+	}
+	q := uploadURL.Query()
+	q.Set("digest", digest.String())
+	uploadURL.RawQuery = q.Encode()
+
+	r.Logf("registry.layer.upload-complete url=%s digest=%s", uploadURL, digest)
+
+	req, err := http.NewRequest("PUT", uploadURL.String(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	r.setUploadToken(req, state)
+
+	resp, err := r.Client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("completing upload failed: %w", newError(resp))
+	}
+	return nil
+}
+
+// updateUploadState sets the location and offset of state from the Location
+// and Range headers of an upload response.
+func (r *Registry) updateUploadState(state *UploadState, resp *http.Response) error {
+	if location := resp.Header.Get("Location"); location != "" {
+		locationURL, err := r.resolveLocation(location)
+		if err != nil {
+			return err
+		}
+		state.Location = locationURL.String()
+	}
+	if token := resp.Header.Get("Request-Token"); token != "" {
+		state.token = token
+	}
+
+	rng := resp.Header.Get("Range")
+	if rng == "" {
+		return nil
+	}
+	// The Range header has the form "0-<last accepted byte>".
+	parts := strings.SplitN(strings.TrimPrefix(rng, "bytes="), "-", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("malformed upload range header: %q", rng)
+	}
+	end, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return fmt.Errorf("malformed upload range header: %q", rng)
+	}
+	state.Offset = end + 1
+	return nil
+}
+
+func (r *Registry) setUploadToken(req *http.Request, state *UploadState) {
+	if state.token != "" {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", state.token))
+	}
+}
+
+// resolveLocation resolves a Location header, which may be relative, against
+// the registry URL.
+func (r *Registry) resolveLocation(location string) (*url.URL, error) {
+	base, err := url.Parse(r.URL)
+	if err != nil {
+		return nil, err
+	}
+	locationURL, err := url.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+	return base.ResolveReference(locationURL), nil
+}
+
+// skip advances content by n bytes.
+func skip(content io.Reader, n int64) error {
+	if seeker, ok := content.(io.Seeker); ok {
+		_, err := seeker.Seek(n, io.SeekStart)
+		return err
+	}
+	_, err := io.CopyN(io.Discard, content, n)
+	return err
+}
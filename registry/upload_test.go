@@ -0,0 +1,146 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/docker/docker/api/types"
+	digest "github.com/opencontainers/go-digest"
+)
+
+// uploadServer is a minimal registry implementing chunked blob uploads.
+type uploadServer struct {
+	l         sync.Mutex
+	data      bytes.Buffer
+	patches   int
+	failPatch int
+	committed digest.Digest
+}
+
+func (s *uploadServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	w.Header().Set("Docker-Distribution-API-Version", "registry/2.0")
+	switch {
+	case r.URL.Path == "/v2/":
+		w.WriteHeader(http.StatusOK)
+	case r.Method == "POST" && r.URL.Path == "/v2/foo/blobs/uploads/":
+		w.Header().Set("Location", "/v2/foo/blobs/uploads/session")
+		w.WriteHeader(http.StatusAccepted)
+	case r.Method == "GET" && r.URL.Path == "/v2/foo/blobs/uploads/session":
+		w.Header().Set("Location", "/v2/foo/blobs/uploads/session")
+		w.Header().Set("Range", fmt.Sprintf("0-%d", s.data.Len()-1))
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == "PATCH" && r.URL.Path == "/v2/foo/blobs/uploads/session":
+		s.patches++
+		if s.patches == s.failPatch {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if want := fmt.Sprintf("%d-", s.data.Len()); !strings.HasPrefix(r.Header.Get("Content-Range"), want) {
+			w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		io.Copy(&s.data, r.Body)
+		w.Header().Set("Location", "/v2/foo/blobs/uploads/session")
+		w.Header().Set("Range", fmt.Sprintf("0-%d", s.data.Len()-1))
+		w.WriteHeader(http.StatusAccepted)
+	case r.Method == "PUT" && r.URL.Path == "/v2/foo/blobs/uploads/session":
+		// A monolithic upload sends the blob with the PUT.
+		io.Copy(&s.data, r.Body)
+		d := digest.Digest(r.URL.Query().Get("digest"))
+		if d != digest.FromBytes(s.data.Bytes()) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		s.committed = d
+		w.WriteHeader(http.StatusCreated)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestUploadLayerChunkedResume(t *testing.T) {
+	us := &uploadServer{failPatch: 3}
+	ts := httptest.NewServer(us)
+	defer ts.Close()
+
+	ctx := context.Background()
+	r, err := New(ctx, types.AuthConfig{ServerAddress: ts.URL}, Opt{ChunkSize: 4})
+	if err != nil {
+		t.Fatalf("expected no error creating client, got %v", err)
+	}
+
+	content := []byte("the quick brown fox")
+	d := digest.FromBytes(content)
+
+	var state UploadState
+	if err := r.UploadLayerChunked(ctx, "foo", d, bytes.NewReader(content), &state); err == nil {
+		t.Fatal("expected the interrupted upload to fail")
+	}
+	if state.Offset != 8 {
+		t.Fatalf("expected offset 8 after two accepted chunks, got %d", state.Offset)
+	}
+
+	if err := r.UploadLayerChunked(ctx, "foo", d, bytes.NewReader(content), &state); err != nil {
+		t.Fatalf("resuming upload failed: %v", err)
+	}
+	if us.committed != d {
+		t.Fatalf("expected committed digest %s, got %s", d, us.committed)
+	}
+	if state.Offset != int64(len(content)) {
+		t.Fatalf("expected offset %d, got %d", len(content), state.Offset)
+	}
+}
+
+func TestUploadBlob(t *testing.T) {
+	content := []byte("the quick brown fox")
+	desc := distribution.Descriptor{Digest: digest.FromBytes(content), Size: int64(len(content))}
+	open := func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(content)), nil
+	}
+
+	// A chunk fails, the upload is resumed from the accepted chunks.
+	us := &uploadServer{failPatch: 3}
+	ts := httptest.NewServer(us)
+	defer ts.Close()
+
+	ctx := context.Background()
+	r, err := New(ctx, types.AuthConfig{ServerAddress: ts.URL}, Opt{ChunkSize: 4})
+	if err != nil {
+		t.Fatalf("expected no error creating client, got %v", err)
+	}
+	if err := r.UploadBlob(ctx, "foo", desc, open); err != nil {
+		t.Fatalf("UploadBlob: %v", err)
+	}
+	if us.committed != desc.Digest {
+		t.Fatalf("expected committed digest %s, got %s", desc.Digest, us.committed)
+	}
+	if us.patches != 6 {
+		t.Fatalf("expected 5 accepted chunks and one failed one, got %d PATCH requests", us.patches)
+	}
+
+	// Blobs that fit into a chunk are uploaded in a single request.
+	us = &uploadServer{}
+	ts2 := httptest.NewServer(us)
+	defer ts2.Close()
+	r, err = New(ctx, types.AuthConfig{ServerAddress: ts2.URL}, Opt{ChunkSize: 64})
+	if err != nil {
+		t.Fatalf("expected no error creating client, got %v", err)
+	}
+	if err := r.UploadBlob(ctx, "foo", desc, open); err != nil {
+		t.Fatalf("UploadBlob: %v", err)
+	}
+	if us.patches != 0 {
+		t.Fatalf("expected no chunked upload, got %d PATCH requests", us.patches)
+	}
+}
@@ -0,0 +1,69 @@
+package registry
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	digest "github.com/opencontainers/go-digest"
+)
+
+var (
+	// ErrDigestMismatch is returned when downloaded content does not match its digest.
+	ErrDigestMismatch = errors.New("digest mismatch")
+	// ErrSizeMismatch is returned when downloaded content does not match its size.
+	ErrSizeMismatch = errors.New("size mismatch")
+)
+
+// verifyingReader checks the content read from the wrapped reader against a
+// digest and size.
+type verifyingReader struct {
+	rc       io.ReadCloser
+	digest   digest.Digest
+	verifier digest.Verifier
+	size     int64
+	n        int64
+}
+
+// VerifyReader wraps rc so that its content is checked against dgst and size
+// while it is read. Instead of io.EOF, the final Read returns an error
+// wrapping ErrDigestMismatch or ErrSizeMismatch if the content does not
+// match. A negative size is not checked.
+func VerifyReader(rc io.ReadCloser, dgst digest.Digest, size int64) (io.ReadCloser, error) {
+	if err := dgst.Validate(); err != nil {
+		return nil, err
+	}
+	return &verifyingReader{
+		rc:       rc,
+		digest:   dgst,
+		verifier: dgst.Verifier(),
+		size:     size,
+	}, nil
+}
+
+func (v *verifyingReader) Read(p []byte) (int, error) {
+	n, err := v.rc.Read(p)
+	if n > 0 {
+		v.verifier.Write(p[:n])
+		v.n += int64(n)
+	}
+
+	if v.size >= 0 && v.n > v.size {
+		return n, fmt.Errorf("%w: %s is larger than the expected %d bytes", ErrSizeMismatch, v.digest, v.size)
+	}
+
+	if err == io.EOF {
+		if v.size >= 0 && v.n != v.size {
+			return n, fmt.Errorf("%w: got %d bytes for %s, expected %d", ErrSizeMismatch, v.n, v.digest, v.size)
+		}
+		if !v.verifier.Verified() {
+			return n, fmt.Errorf("%w: content does not match %s", ErrDigestMismatch, v.digest)
+		}
+	}
+
+	return n, err
+}
+
+func (v *verifyingReader) Close() error {
+	return v.rc.Close()
+}
@@ -0,0 +1,87 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	digest "github.com/opencontainers/go-digest"
+)
+
+func TestVerifyReader(t *testing.T) {
+	content := "hello world"
+	d := digest.FromString(content)
+
+	testcases := []struct {
+		content string
+		size    int64
+		wantErr error
+	}{
+		{content: content, size: int64(len(content))},
+		{content: content, size: -1},
+		{content: "hello w0rld", size: int64(len(content)), wantErr: ErrDigestMismatch},
+		{content: "hello", size: int64(len(content)), wantErr: ErrSizeMismatch},
+		{content: content + "!", size: int64(len(content)), wantErr: ErrSizeMismatch},
+	}
+	for _, tc := range testcases {
+		rc, err := VerifyReader(io.NopCloser(strings.NewReader(tc.content)), d, tc.size)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = io.ReadAll(rc)
+		if !errors.Is(err, tc.wantErr) {
+			t.Fatalf("reading %q with size %d: expected error %v, got %v", tc.content, tc.size, tc.wantErr, err)
+		}
+	}
+}
+
+func TestDownloadLayer(t *testing.T) {
+	good := digest.FromString("layer")
+	corrupt := digest.FromString("other")
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Docker-Distribution-API-Version", "registry/2.0")
+		switch r.URL.Path {
+		case "/v2/":
+		case "/v2/foo/blobs/" + good.String(), "/v2/foo/blobs/" + corrupt.String():
+			w.Write([]byte("layer"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"errors":[{"code":"BLOB_UNKNOWN","message":"blob unknown to registry"}]}`))
+		}
+	}))
+	defer ts.Close()
+
+	ctx := context.Background()
+	r, err := New(ctx, types.AuthConfig{ServerAddress: ts.URL}, Opt{})
+	if err != nil {
+		t.Fatalf("expected no error creating client, got %v", err)
+	}
+
+	rc, err := r.DownloadLayer(ctx, "foo", good)
+	if err != nil {
+		t.Fatalf("downloading layer failed: %v", err)
+	}
+	if b, err := io.ReadAll(rc); err != nil || string(b) != "layer" {
+		t.Fatalf("expected layer content, got %q, %v", b, err)
+	}
+	rc.Close()
+
+	rc, err = r.DownloadLayer(ctx, "foo", corrupt)
+	if err != nil {
+		t.Fatalf("downloading layer failed: %v", err)
+	}
+	if _, err := io.ReadAll(rc); !errors.Is(err, ErrDigestMismatch) {
+		t.Fatalf("expected ErrDigestMismatch, got %v", err)
+	}
+	rc.Close()
+
+	if _, err := r.DownloadLayer(ctx, "bar", good); !errors.Is(err, ErrResourceNotFound) {
+		t.Fatalf("expected ErrResourceNotFound, got %v", err)
+	}
+}
@@ -2,9 +2,12 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"strings"
 
+	"github.com/sirupsen/logrus"
 	"github.com/ttys3/reg/registry"
 )
 
@@ -13,12 +16,18 @@ const removeHelp = `Delete a specific reference of a repository.`
 func (cmd *removeCommand) Name() string      { return "rm" }
 func (cmd *removeCommand) Args() string      { return "[OPTIONS] NAME[:TAG|@DIGEST]" }
 func (cmd *removeCommand) ShortHelp() string { return removeHelp }
-func (cmd *removeCommand) LongHelp() string  { return removeHelp }
-func (cmd *removeCommand) Hidden() bool      { return false }
+func (cmd *removeCommand) LongHelp() string {
+	return removeHelp + "\n\nA tag is removed on its own where the registry supports deleting tags. Otherwise the\nmanifest is deleted by digest, which removes every other tag pointing at it too,\nso --force is required if there are any."
+}
+func (cmd *removeCommand) Hidden() bool { return false }
 
-func (cmd *removeCommand) Register(fs *flag.FlagSet) {}
+func (cmd *removeCommand) Register(fs *flag.FlagSet) {
+	fs.BoolVar(&cmd.force, "force", false, "delete the manifest even if other tags point at it")
+}
 
-type removeCommand struct{}
+type removeCommand struct {
+	force bool
+}
 
 func (cmd *removeCommand) Run(ctx context.Context, args []string) error {
 	if len(args) < 1 {
@@ -36,10 +45,53 @@ func (cmd *removeCommand) Run(ctx context.Context, args []string) error {
 		return err
 	}
 
-	// Get the digest.
-	digest, err := r.Digest(ctx, image)
-	if err != nil {
-		return err
+	// What gets deleted is decided by reads, which a stale mirror must not
+	// answer.
+	ctx = registry.WithoutMirrors(ctx)
+
+	// Delete just the tag if the registry supports it.
+	if image.Digest == "" {
+		err := r.DeleteTag(ctx, image.Path, image.Tag)
+		if err == nil {
+			fmt.Printf("Deleted %s\n", image.String())
+			return nil
+		}
+		if !errors.Is(err, registry.ErrUnsupported) {
+			return err
+		}
+		logrus.Infof("registry does not support deleting tags, deleting %s by digest", image.String())
+	}
+
+	// Get the digest the reference points at, which is the manifest list or
+	// index for multi-platform images, as TagsForDigest compares against.
+	digest := image.Digest
+	if digest == "" {
+		desc, err := r.HeadManifest(ctx, image.Path, image.Tag)
+		if err != nil {
+			return err
+		}
+		if desc.Digest == "" {
+			return fmt.Errorf("registry did not return the digest of %s", image.String())
+		}
+		digest = desc.Digest
+	}
+
+	// Deleting the digest removes every tag pointing at it.
+	if !cmd.force {
+		tags, err := r.TagsForDigest(ctx, image.Path, digest)
+		if err != nil {
+			return fmt.Errorf("finding the tags of %s failed: %w", digest, err)
+		}
+		var others []string
+		for _, tag := range tags {
+			if tag != image.Tag || image.Digest != "" {
+				others = append(others, tag)
+			}
+		}
+		if len(others) > 0 {
+			return fmt.Errorf("%s is also tagged %s in %s, deleting it removes those tags too, pass --force to delete it anyway",
+				digest, strings.Join(others, ", "), image.Path)
+		}
 	}
 
 	if err := image.WithDigest(digest); err != nil {
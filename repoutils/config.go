@@ -0,0 +1,146 @@
+package repoutils
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds the settings of registries, keyed by registry host, as read
+// from the registries config file:
+//
+//	registry.example.com:
+//	  ca: certs/ca.pem
+//	  credentials:
+//	    username: ci
+//	    password-env: REGISTRY_PASSWORD
+//	  headers:
+//	    X-Meta-Team: platform
+//	  timeout: 30s
+//	docker.io:
+//	  mirrors:
+//	    - mirror.example.com:5000
+type Config map[string]RegistryConfig
+
+// RegistryConfig holds the settings of a single registry.
+type RegistryConfig struct {
+	Insecure    bool              `yaml:"insecure"`
+	NonSSL      bool              `yaml:"non-ssl"`
+	CA          string            `yaml:"ca"`
+	Cert        string            `yaml:"cert"`
+	Key         string            `yaml:"key"`
+	AuthURL     string            `yaml:"auth-url"`
+	Credentials Credentials       `yaml:"credentials"`
+	Headers     map[string]string `yaml:"headers"`
+	Mirrors     []string          `yaml:"mirrors"`
+	Timeout     time.Duration     `yaml:"timeout"`
+}
+
+// Credentials configures where the credentials of a registry come from. The
+// password is read from the first of Password, PasswordEnv and PasswordFile
+// that is set. Without any credentials the docker config file is used.
+type Credentials struct {
+	Username     string `yaml:"username"`
+	Password     string `yaml:"password"`
+	PasswordEnv  string `yaml:"password-env"`
+	PasswordFile string `yaml:"password-file"`
+}
+
+// DefaultConfigPath returns the default location of the registries config
+// file, registries.yaml in the reg directory of the user config directory.
+func DefaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "reg", "registries.yaml")
+}
+
+// LoadConfig reads the registries config file at path. A missing file is an
+// empty config. Relative file paths in the config are relative to the
+// directory of the config file.
+func LoadConfig(path string) (Config, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return Config{}, nil
+		}
+		return nil, fmt.Errorf("reading config file %s failed: %v", path, err)
+	}
+
+	var c Config
+	if err := yaml.Unmarshal(b, &c); err != nil {
+		return nil, fmt.Errorf("parsing config file %s failed: %v", path, err)
+	}
+
+	dir := filepath.Dir(path)
+	for host, rc := range c {
+		rc.CA = resolvePath(dir, rc.CA)
+		rc.Cert = resolvePath(dir, rc.Cert)
+		rc.Key = resolvePath(dir, rc.Key)
+		rc.Credentials.PasswordFile = resolvePath(dir, rc.Credentials.PasswordFile)
+		c[host] = rc
+	}
+	return c, nil
+}
+
+// Lookup returns the settings of the registry at domain.
+func (c Config) Lookup(domain string) (RegistryConfig, bool) {
+	domain = NormalizeDomain(domain)
+	for host, rc := range c {
+		if NormalizeDomain(host) == domain {
+			return rc, true
+		}
+	}
+	return RegistryConfig{}, false
+}
+
+// Resolve returns the configured username and password.
+func (c Credentials) Resolve() (string, string, error) {
+	switch {
+	case c.Password != "":
+		return c.Username, c.Password, nil
+	case c.PasswordEnv != "":
+		return c.Username, os.Getenv(c.PasswordEnv), nil
+	case c.PasswordFile != "":
+		b, err := os.ReadFile(c.PasswordFile)
+		if err != nil {
+			return "", "", fmt.Errorf("reading password file failed: %v", err)
+		}
+		return c.Username, strings.TrimSpace(string(b)), nil
+	}
+	return c.Username, "", nil
+}
+
+// NormalizeDomain strips the scheme from a registry address and maps the
+// different names of Docker Hub to docker.io.
+func NormalizeDomain(domain string) string {
+	domain = strings.TrimPrefix(strings.TrimPrefix(domain, "https://"), "http://")
+	domain = strings.TrimSuffix(domain, "/")
+	switch domain {
+	case "", "docker.io", "index.docker.io", "registry-1.docker.io", "index.docker.io/v1":
+		return "docker.io"
+	}
+	return domain
+}
+
+func resolvePath(dir, p string) string {
+	if p == "" {
+		return p
+	}
+	if strings.HasPrefix(p, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, p[2:])
+		}
+	}
+	if filepath.IsAbs(p) {
+		return p
+	}
+	return filepath.Join(dir, p)
+}
@@ -0,0 +1,99 @@
+package repoutils
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "registries.yaml")
+	if err := os.WriteFile(path, []byte(`
+registry.example.com:
+  ca: certs/ca.pem
+  cert: /etc/reg/client.pem
+  auth-url: auth.example.com
+  credentials:
+    username: ci
+    password-env: REG_TEST_PASSWORD
+  headers:
+    X-Meta-Team: platform
+  timeout: 30s
+index.docker.io:
+  mirrors:
+    - mirror.example.com
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+
+	rc, ok := c.Lookup("https://registry.example.com/")
+	if !ok {
+		t.Fatal("expected an entry for registry.example.com")
+	}
+	expected := RegistryConfig{
+		CA:      filepath.Join(dir, "certs/ca.pem"),
+		Cert:    "/etc/reg/client.pem",
+		AuthURL: "auth.example.com",
+		Credentials: Credentials{
+			Username:    "ci",
+			PasswordEnv: "REG_TEST_PASSWORD",
+		},
+		Headers: map[string]string{"X-Meta-Team": "platform"},
+		Timeout: 30 * time.Second,
+	}
+	if diff := cmp.Diff(expected, rc); diff != "" {
+		t.Errorf("config differs: (-want +got)\n%s", diff)
+	}
+
+	t.Setenv("REG_TEST_PASSWORD", "secret")
+	username, password, err := rc.Credentials.Resolve()
+	if err != nil {
+		t.Fatalf("Resolve: %v", err)
+	}
+	if username != "ci" || password != "secret" {
+		t.Errorf("expected ci/secret, got %s/%s", username, password)
+	}
+
+	hub, ok := c.Lookup("docker.io")
+	if !ok || len(hub.Mirrors) != 1 {
+		t.Errorf("expected the index.docker.io entry for docker.io, got %+v", hub)
+	}
+
+	if _, ok := c.Lookup("other.example.com"); ok {
+		t.Error("expected no entry for other.example.com")
+	}
+}
+
+func TestLoadConfigMissing(t *testing.T) {
+	c, err := LoadConfig(filepath.Join(t.TempDir(), "registries.yaml"))
+	if err != nil {
+		t.Fatalf("expected a missing config file to be empty, got %v", err)
+	}
+	if len(c) != 0 {
+		t.Errorf("expected an empty config, got %v", c)
+	}
+}
+
+func TestCredentialsPasswordFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "password")
+	if err := os.WriteFile(path, []byte("secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	_, password, err := Credentials{Username: "ci", PasswordFile: path}.Resolve()
+	if err != nil {
+		t.Fatalf("Resolve: %v", err)
+	}
+	if password != "secret" {
+		t.Errorf("expected secret, got %q", password)
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+	"github.com/distribution/distribution/v3/manifest/schema2"
+	"github.com/distribution/reference"
+	"github.com/sirupsen/logrus"
+	"github.com/ttys3/reg/layout"
+	"github.com/ttys3/reg/registry"
+)
+
+const saveHelp = `Save images to a tarball that docker load can read.`
+
+func (cmd *saveCommand) Name() string      { return "save" }
+func (cmd *saveCommand) Args() string      { return "[OPTIONS] NAME[:TAG|@DIGEST]..." }
+func (cmd *saveCommand) ShortHelp() string { return saveHelp }
+func (cmd *saveCommand) LongHelp() string {
+	return saveHelp + "\n\nThe tarball is in the docker-archive format that docker load and podman load read.\nFrom a manifest list the image for --platform is saved, linux/amd64 by default.\nLayers shared between images are only stored once."
+}
+func (cmd *saveCommand) Hidden() bool { return false }
+
+func (cmd *saveCommand) Register(fs *flag.FlagSet) {
+	fs.StringVar(&cmd.output, "output", "", "tarball to write the images to")
+	fs.StringVar(&cmd.output, "o", "", "tarball to write the images to")
+}
+
+type saveCommand struct {
+	output string
+}
+
+func (cmd *saveCommand) Run(ctx context.Context, args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("pass the name of the image")
+	}
+	if cmd.output == "" {
+		return fmt.Errorf("pass the tarball to write to with -o")
+	}
+
+	// Write to a temporary file next to the output, so that an interrupted
+	// save does not leave a partial tarball behind.
+	f, err := os.CreateTemp(filepath.Dir(cmd.output), "."+filepath.Base(cmd.output)+".part-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	w := layout.NewArchiveWriter(f)
+	clients := map[string]*registry.Registry{}
+	for _, arg := range args {
+		image, err := registry.ParseImage(arg)
+		if err != nil {
+			return err
+		}
+
+		// Create the registry client, once per registry.
+		r, ok := clients[image.Domain]
+		if !ok {
+			if r, err = createRegistryClient(ctx, image.Domain); err != nil {
+				return err
+			}
+			clients[image.Domain] = r
+		}
+
+		desc, err := saveImage(ctx, r, image, w)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Saved %s@%s to %s\n", image.String(), desc.Digest, cmd.output)
+	}
+
+	if err := w.Close(); err != nil {
+		return err
+	}
+	if err := f.Chmod(0644); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), cmd.output)
+}
+
+// saveImage writes the config and layers of an image to the archive and
+// lists it under its tag. It returns the descriptor of the image manifest,
+// which for a manifest list is the one of the selected platform.
+func saveImage(ctx context.Context, r *registry.Registry, image registry.Image, w *layout.ArchiveWriter) (distribution.Descriptor, error) {
+	m, desc, err := r.ResolveManifest(ctx, image.Path, image.Reference(), registry.Platform{})
+	if err != nil {
+		return desc, fmt.Errorf("getting manifest for %s failed: %v", image.String(), err)
+	}
+
+	var (
+		config distribution.Descriptor
+		layers []distribution.Descriptor
+	)
+	switch m := m.(type) {
+	case *schema2.DeserializedManifest:
+		config, layers = m.Config, m.Layers
+	case *ocischema.DeserializedManifest:
+		config, layers = m.Config, m.Layers
+	default:
+		return desc, fmt.Errorf("%w: cannot save %s, it is not an image manifest", registry.ErrUnsupported, image.String())
+	}
+
+	// Blobs are written to the tarball one after the other, each downloaded
+	// in parallel ranges.
+	for _, blob := range append([]distribution.Descriptor{config}, layers...) {
+		if w.HasBlob(blob.Digest) {
+			logrus.Debugf("blob %s already saved, skipping", blob.Digest)
+			continue
+		}
+
+		logrus.Infof("saving blob %s (%d bytes)", blob.Digest, blob.Size)
+		rc, err := r.FetchBlobReader(ctx, image.Path, blob)
+		if err != nil {
+			return desc, fmt.Errorf("downloading blob %s failed: %v", blob.Digest, err)
+		}
+		err = w.WriteBlob(blob, rc)
+		rc.Close()
+		if err != nil {
+			return desc, err
+		}
+	}
+
+	// docker load names images in their familiar form, like alpine:3.18.
+	var tags []string
+	if image.Tag != "" {
+		named, err := reference.ParseNormalizedNamed(image.String())
+		if err != nil {
+			return desc, err
+		}
+		tags = append(tags, reference.FamiliarName(named)+":"+image.Tag)
+	}
+	return desc, w.AddImage(config, layers, tags)
+}
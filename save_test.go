@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ttys3/reg/layout"
+)
+
+func TestSave(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "images.tar")
+	image := fmt.Sprintf("%s/busybox:latest", domain)
+
+	out, err := run("save", "-o", output, image, fmt.Sprintf("%s/alpine:latest", domain))
+	if err != nil {
+		t.Fatalf("output: %s, error: %v", out, err)
+	}
+	if !strings.Contains(out, "Saved "+image) {
+		t.Fatalf("expected the image to be saved, got: %s", out)
+	}
+
+	a, err := layout.OpenArchive(output)
+	if err != nil {
+		t.Fatalf("opening the tarball failed: %v", err)
+	}
+	defer a.Close()
+
+	if len(a.Manifest) != 2 {
+		t.Fatalf("expected 2 images in manifest.json, got %+v", a.Manifest)
+	}
+	m, _, err := a.Image(fmt.Sprintf("%s/busybox:latest", domain))
+	if err != nil {
+		t.Fatalf("expected busybox in the tarball: %v", err)
+	}
+	if len(m.Layers) == 0 {
+		t.Fatal("expected busybox to have layers")
+	}
+}
+
+func TestSaveRejectsPlatformList(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "images.tar")
+	image := fmt.Sprintf("%s/busybox:latest", domain)
+
+	out, err := run("save", "--platform", "linux/arm64,linux/amd64", "-o", output, image)
+	if err == nil {
+		t.Fatalf("expected saving with a list of platforms to fail, got: %s", out)
+	}
+	if !strings.Contains(out, "only cp, pull and push take more than one platform") {
+		t.Fatalf("expected an error about the list of platforms, got: %s", out)
+	}
+}